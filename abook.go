@@ -0,0 +1,208 @@
+package contacts
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/xconstruct/vdir"
+)
+
+// The addressbook file of abook, one section per contact:
+//
+//	[format]
+//	program=abook
+//	version=0.6.1
+//
+//	[0]
+//	name=John Doe
+//	email=john.doe@example.com,jdoe@example.org
+//	nick=jd
+//	mobile=+49 170 1234567
+
+// phone fields by vCard type
+var abookPhones = []struct {
+	field string
+	kind  string
+}{
+	{"mobile", "cell"},
+	{"fax", "fax"},
+	{"workphone", "work"},
+	{"phone", "home"},
+}
+
+// Write cards as an abook file
+func writeAbook(writer io.Writer, cards []vdir.Card, opts ExportOptions) error {
+	w := bufio.NewWriter(writer)
+	fmt.Fprint(w, "# abook addressbook file\n\n[format]\nprogram=abook\nversion=0.6.1\n")
+
+	strip := func(name string) bool {
+		return stripped(name, opts.Strip)
+	}
+	for i, card := range cards {
+		fmt.Fprintf(w, "\n[%d]\n", i)
+		field := func(name, value string) {
+			// abook has no escaping, values are single lines
+			value = strings.Join(strings.Fields(value), " ")
+			if value != "" {
+				fmt.Fprintf(w, "%s=%s\n", name, value)
+			}
+		}
+
+		if !strip("FN") {
+			field("name", FormatName(card))
+		}
+		if !strip("EMAIL") {
+			emails := []string{}
+			for _, mail := range card.Email {
+				emails = append(emails, mail.Value)
+			}
+			field("email", strings.Join(emails, ","))
+		}
+		if !strip("NICKNAME") {
+			field("nick", firstValue(card.NickName))
+		}
+
+		done := map[string]bool{}
+		phones := card.Telephones
+		if strip("TEL") {
+			phones = nil
+		}
+		for _, tel := range phones {
+			name := "phone"
+			for _, phone := range abookPhones {
+				if hasType(tel.Type, phone.kind) {
+					name = phone.field
+					break
+				}
+			}
+			// abook has a single value per field
+			if !done[name] {
+				done[name] = true
+				field(name, tel.Value)
+			}
+		}
+
+		if len(card.Addresses) > 0 && !strip("ADR") {
+			adr := card.Addresses[0]
+			street := strings.SplitN(adr.Street, "\n", 2)
+			field("address", street[0])
+			if len(street) > 1 {
+				field("address2", street[1])
+			}
+			field("city", adr.Locality)
+			field("state", adr.Region)
+			field("zip", adr.PostalCode)
+			field("country", adr.CountryName)
+		}
+		if len(card.Url) > 0 && !strip("URL") {
+			field("url", card.Url[0].Value)
+		}
+		if !strip("NOTE") {
+			field("notes", card.Note)
+		}
+		if !strip("BDAY") {
+			field("anniversary", extendedDateTime(card.Birthday))
+		}
+		if !strip("CATEGORIES") {
+			field("groups", strings.Join(card.Categories, ","))
+		}
+	}
+	return w.Flush()
+}
+
+// Read an abook file, each numbered section becomes a card.
+// Sections that cannot be read are reported in the list of errors.
+func readAbook(reader io.Reader) ([]vdir.Card, []error, error) {
+	cards := []vdir.Card{}
+	failed := []error{}
+	scanner := bufio.NewScanner(reader)
+
+	var fields map[string]string
+	start := 0
+	broken := false
+	flush := func() {
+		if fields != nil && !broken {
+			cards = append(cards, abookCard(fields))
+		}
+		fields = nil
+		broken = false
+	}
+
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			flush()
+			// [format] describes the file
+			if _, err := strconv.Atoi(line[1 : len(line)-1]); err == nil {
+				fields = map[string]string{}
+				start = lineno
+			}
+			continue
+		}
+		if fields == nil {
+			continue
+		}
+		eq := strings.Index(line, "=")
+		if eq < 0 {
+			failed = append(failed, fmt.Errorf("Contact at line %d: %v",
+				start, &SyntaxError{lineno, fmt.Sprintf("missing '=' in %q", line)}))
+			broken = true
+			continue
+		}
+		fields[strings.ToLower(strings.TrimSpace(line[:eq]))] = strings.TrimSpace(line[eq+1:])
+	}
+	flush()
+	return cards, failed, scanner.Err()
+}
+
+func abookCard(fields map[string]string) vdir.Card {
+	var card vdir.Card
+	card.FormattedName = fields["name"]
+	// abook has no separate name fields, only "First Last" is split
+	if parts := strings.Fields(card.FormattedName); len(parts) == 2 {
+		card.Name.GivenName = []string{parts[0]}
+		card.Name.FamilyName = []string{parts[1]}
+	}
+	for _, mail := range multiple(fields["email"]) {
+		card.Email = append(card.Email, vdir.TypedValue{Value: mail})
+	}
+	card.NickName = multiple(fields["nick"])
+
+	for i := len(abookPhones) - 1; i >= 0; i-- {
+		phone := abookPhones[i]
+		if value := fields[phone.field]; value != "" {
+			card.Telephones = append(card.Telephones,
+				vdir.TypedValue{Type: []string{phone.kind}, Value: value})
+		}
+	}
+
+	adr := vdir.Address{
+		Street:      fields["address"],
+		Locality:    fields["city"],
+		Region:      fields["state"],
+		PostalCode:  fields["zip"],
+		CountryName: fields["country"],
+	}
+	if fields["address2"] != "" {
+		adr.Street += "\n" + fields["address2"]
+	}
+	if adr.Street+adr.Locality+adr.Region+adr.PostalCode+adr.CountryName != "" {
+		card.Addresses = []vdir.Address{adr}
+	}
+
+	if url := fields["url"]; url != "" {
+		card.Url = []vdir.TypedValue{{Value: url}}
+	}
+	card.Note = fields["notes"]
+	card.Birthday = basicDateTime(fields["anniversary"])
+	card.Categories = multiple(fields["groups"])
+	return card
+}
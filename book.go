@@ -1,32 +1,79 @@
 package contacts
 
 import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
+	"unicode"
 
 	"github.com/pborman/uuid"
 	"github.com/xconstruct/vdir"
 )
 
 type Addressbook struct {
+	// Name from the configuration, see Configuration.Addressbooks
+	Name    string
 	Dirname string
+	// Version is the vCard version for saved cards,
+	// if empty, cards are saved as vdir writes them.
+	Version string
 	cards   []vdir.Card
+	stamps  map[string]stamp
+	// UID -> the file a card was loaded from or saved to
+	paths  map[string]string
+	errors []LoadError
+}
+
+// A LoadError describes a card file that could not be read.
+// Line is zero if the error is not related to a specific line.
+type LoadError struct {
+	Path string
+	Line int
+	Err  error
+}
+
+func (e LoadError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s:%d: %v", e.Path, e.Line, e.Err)
+	}
+	return fmt.Sprintf("%s: %v", e.Path, e.Err)
 }
 
 func NewAddressbook(dirname string) *Addressbook {
 	book := new(Addressbook)
 	book.Dirname = dirname
+	book.stamps = make(map[string]stamp)
+	book.paths = make(map[string]string)
 	return book
 }
 
+// Find cards matching the query, best matches first.
+// Unlike Rank, similar cards are not included.
 func (b *Addressbook) Find(query Query) ([]vdir.Card, error) {
-	var err error
 	var found []vdir.Card
+	matches, err := b.Rank(query)
+	for _, match := range ExactMatches(matches) {
+		found = append(found, match.Card)
+	}
+	return found, err
+}
+
+// Find cards matching the query and score them.
+// In addition to exact matches, cards that are similar to plain search terms
+// are included (see Query.Score), these have Match.Fuzzy set.
+// Results are sorted by score, then by name.
+func (b *Addressbook) Rank(query Query) ([]Match, error) {
+	var err error
+	var found []Match
 	if b.cards == nil {
 		err = b.load()
 		if err != nil {
@@ -34,19 +81,107 @@ func (b *Addressbook) Find(query Query) ([]vdir.Card, error) {
 		}
 	}
 
-	for _, card := range b.cards {
+	cards := make([]vdir.Card, len(b.cards))
+	copy(cards, b.cards)
+	sort.Sort(ByName(cards))
+	fuzzy := query.fuzzyTerm() != ""
+	for _, card := range cards {
+		score := query.Score(card)
 		if query.Matches(card) {
-			found = append(found, card)
+			found = append(found, Match{card, score, b, false})
+		} else if fuzzy && score >= fuzzyThreshold && query.matchCategories(card) {
+			found = append(found, Match{card, score, b, true})
 		}
 	}
+	sort.Stable(byScore(found))
 	return found, err
 }
 
+// Several address books that are searched together.
+type Addressbooks []*Addressbook
+
+// Find cards matching the query in all address books, best matches first.
+// Unlike Rank, similar cards are not included.
+func (l Addressbooks) Find(query Query) ([]vdir.Card, error) {
+	var found []vdir.Card
+	matches, err := l.Rank(query)
+	for _, match := range ExactMatches(matches) {
+		found = append(found, match.Card)
+	}
+	return found, err
+}
+
+// Find and score cards in all address books, see Addressbook.Rank.
+// Each match tells which address book the card is from.
+func (l Addressbooks) Rank(query Query) ([]Match, error) {
+	var found []Match
+	for _, book := range l {
+		matches, err := book.Rank(query)
+		if err != nil {
+			return found, fmt.Errorf("%v: %v", book.Name, err)
+		}
+		found = append(found, matches...)
+	}
+	sort.SliceStable(found, func(i, j int) bool {
+		return FormatName(found[i].Card) < FormatName(found[j].Card)
+	})
+	sort.Stable(byScore(found))
+	return found, nil
+}
+
+// Return the errors for card files in all address books.
+func (l Addressbooks) LoadErrors() ([]LoadError, error) {
+	var all []LoadError
+	for _, book := range l {
+		loadErrors, err := book.LoadErrors()
+		if err != nil {
+			return all, fmt.Errorf("%v: %v", book.Name, err)
+		}
+		all = append(all, loadErrors...)
+	}
+	return all, nil
+}
+
+// Return the errors for card files that could not be loaded.
+// Broken cards are skipped when the address book is loaded.
+func (b *Addressbook) LoadErrors() ([]LoadError, error) {
+	if b.cards == nil {
+		err := b.load()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b.errors, nil
+}
+
+// Check if there is a card with the given UID.
+func (b *Addressbook) Contains(uid string) (bool, error) {
+	if b.cards == nil {
+		err := b.load()
+		if err != nil {
+			return false, err
+		}
+	}
+	for _, card := range b.cards {
+		if card.Uid == uid {
+			return true, nil
+		}
+	}
+	// saved since the address book was loaded
+	_, err := os.Stat(b.cardPath(vdir.Card{Uid: uid}))
+	if err == nil {
+		return true, nil
+	} else if !os.IsNotExist(err) {
+		return false, err
+	}
+	return false, nil
+}
+
 // Save the given card
-// the filename is derived from the cards UID.
+// to the file it was loaded from, or a file named after the cards UID.
 // if no UID is set, one is assigned
 // also set the Rev field
-func (b Addressbook) Save(card vdir.Card) error {
+func (b *Addressbook) Save(card vdir.Card) error {
 	if card.Uid == "" {
 		// assume this is a new contact
 		card.Uid = uuid.New()
@@ -55,26 +190,306 @@ func (b Addressbook) Save(card vdir.Card) error {
 	card.Rev = time.Now().UTC().Format(time.RFC3339)
 	card.FormattedName = FormatName(card)
 
-	bytes, err := vdir.Marshal(card)
+	data, err := vdir.Marshal(card)
+	if b.Version != "" && err == nil {
+		data, err = marshalCard(card, b.Version)
+	}
+	if err != nil {
+		return err
+	}
+
+	return b.write(card, data)
+}
+
+// Add an imported card.
+// Cards that were read with their properties (vCard, jCard, xCard)
+// are written as they are, so parameters and properties that vdir
+// does not know are kept; others are saved like with Save.
+// If no UID is set, one is assigned.
+func (b *Addressbook) Import(card ImportedCard) error {
+	if card.props == nil {
+		return b.Save(card.Card)
+	}
+	props := card.props
+	if card.Uid == "" {
+		card.Uid = uuid.New()
+		props = append(props, property{Name: "UID", Value: card.Uid})
+	}
+	var err error
+	if b.Version != "" {
+		props, err = convertProperties(props, b.Version)
+		if err != nil {
+			return err
+		}
+	}
+	var buf bytes.Buffer
+	err = writeCard(&buf, props)
 	if err != nil {
 		return err
 	}
+	return b.write(card.Card, buf.Bytes())
+}
+
+// write the data for a card and remember the state of the file
+func (b *Addressbook) write(card vdir.Card, data []byte) error {
+	path := b.cardPath(card)
+	err := writeFileAtomic(path, data)
+	if err != nil {
+		return err
+	}
+	if b.stamps == nil {
+		b.stamps = make(map[string]stamp)
+	}
+	b.stamps[path] = newStamp(path, card, data)
+	b.setPath(card.Uid, path)
+	return nil
+}
+
+// The properties of a card as they are in its file,
+// without BEGIN and END.
+func (b *Addressbook) fileProperties(card vdir.Card) ([]property, error) {
+	data, err := ioutil.ReadFile(b.cardPath(card))
+	if err != nil {
+		return nil, err
+	}
+	props, err := parseProperties(data)
+	if err != nil {
+		return nil, err
+	}
+	err = checkSingleCard(props)
+	if err != nil {
+		return nil, err
+	}
+	return props[1 : len(props)-1], nil
+}
 
+// Copy a card to the `target` address book.
+// The file is copied as it is, so properties that are unknown to vdir
+// are kept. With `newUID`, the copy gets a new UID, otherwise the UID
+// is kept and the copy fails if the target has a card with that UID.
+// Returns the UID of the copy.
+func (b *Addressbook) Copy(card vdir.Card, target *Addressbook, newUID bool) (string, error) {
+	if card.Uid == "" {
+		return "", errors.New("Contact has no UID.")
+	}
+	data, err := ioutil.ReadFile(b.cardPath(card))
+	if err != nil {
+		return "", err
+	}
+	uid := card.Uid
+	if newUID {
+		uid = uuid.New()
+		data, err = replaceUID(data, uid)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	exists, err := target.Contains(uid)
+	if err != nil {
+		return "", err
+	} else if exists {
+		return "", fmt.Errorf("A contact with UID %v exists in %v.", uid, target.Name)
+	}
+	path := target.cardPath(vdir.Card{Uid: uid})
+	err = writeFileAtomic(path, data)
+	if err != nil {
+		return "", err
+	}
+	target.setPath(uid, path)
+	return uid, nil
+}
+
+// Move a card to the `target` address book.
+// The card is removed from this address book
+// only after the copy was written.
+func (b *Addressbook) Move(card vdir.Card, target *Addressbook) error {
+	if filepath.Clean(b.Dirname) == filepath.Clean(target.Dirname) {
+		return fmt.Errorf("Contact is already in %v.", target.Name)
+	}
+	_, err := b.Copy(card, target, false)
+	if err != nil {
+		return err
+	}
 	path := b.cardPath(card)
-	file, err := os.Create(path)
+	err = os.Remove(path)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-	_, err = file.Write(bytes)
+	delete(b.stamps, path)
+	delete(b.paths, card.Uid)
+	return syncDir(b.Dirname)
+}
+
+// set the UID of a single vCard
+func replaceUID(data []byte, uid string) ([]byte, error) {
+	props, err := parseProperties(data)
+	if err != nil {
+		return nil, err
+	}
+	err = checkSingleCard(props)
+	if err != nil {
+		return nil, err
+	}
+	props = props[1 : len(props)-1]
+	found := false
+	for i, prop := range props {
+		if prop.Name == "UID" {
+			props[i] = property{Name: "UID", Value: uid}
+			found = true
+		}
+	}
+	if !found {
+		props = append(props, property{Name: "UID", Value: uid})
+	}
+	var buf bytes.Buffer
+	err = writeCard(&buf, props)
+	return buf.Bytes(), err
+}
+
+// Convert all cards to the given vCard version.
+// The files are converted as they are, without reading them into a card,
+// so properties that are unknown to vdir are kept.
+// Returns the paths of converted files; broken files are skipped
+// and reported as LoadErrors.
+func (b *Addressbook) Convert(version string, dryRun bool) ([]string, []LoadError, error) {
+	converted := []string{}
+	failed := []LoadError{}
+	paths, err := filepath.Glob(filepath.Join(b.Dirname, "*.vcf"))
+	if err != nil {
+		return converted, failed, err
+	}
+	for _, path := range paths {
+		changed, err := convertFile(path, version, dryRun)
+		if err != nil {
+			log.Printf("Skip %s: %v", path, err)
+			failed = append(failed, newLoadError(path, err))
+		} else if changed {
+			converted = append(converted, path)
+		}
+	}
+	return converted, failed, nil
+}
+
+func convertFile(path, version string, dryRun bool) (bool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	props, err := parseProperties(data)
+	if err != nil {
+		return false, err
+	}
+	err = checkSingleCard(props)
+	if err != nil {
+		return false, err
+	}
+	props = props[1 : len(props)-1]
+	current := ""
+	for _, prop := range props {
+		if prop.Name == "VERSION" {
+			current = prop.Value
+		}
+	}
+	if current == version && !encodedProperties(props) {
+		return false, nil
+	}
+
+	props, err = convertProperties(props, version)
+	if err != nil {
+		return false, err
+	}
+	if dryRun {
+		return true, nil
+	}
+	var buf bytes.Buffer
+	err = writeCard(&buf, props)
+	if err != nil {
+		return false, err
+	}
+	return true, writeFileAtomic(path, buf.Bytes())
+}
+
+// replaced in tests to simulate failed writes
+var (
+	writeTempFile = writeAndSync
+	renameFile    = os.Rename
+)
+
+// Write `data` to a temporary file in the same directory as `path`
+// and rename it into place when everything is on disk.
+// Readers see either the old or the new file, never a partial one.
+// If the file exists, its mode is kept.
+func writeFileAtomic(path string, data []byte) error {
+	mode := os.FileMode(0644)
+	info, err := os.Stat(path)
+	if err == nil {
+		mode = info.Mode().Perm()
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	// no ".vcf" extension, so load() will ignore leftovers
+	tempfile, err := ioutil.TempFile(filepath.Dir(path),
+		"."+filepath.Base(path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	tempname := tempfile.Name()
+	err = writeTempFile(tempfile, data, mode)
+	if err != nil {
+		os.Remove(tempname)
+		return err
+	}
+
+	err = renameFile(tempname, path)
+	if err != nil {
+		os.Remove(tempname)
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+func writeAndSync(file *os.File, data []byte, mode os.FileMode) error {
+	_, err := file.Write(data)
+	if err == nil {
+		err = file.Chmod(mode)
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
 	return err
 }
 
+// fsync the directory so that a rename survives a crash
+func syncDir(dirname string) error {
+	dir, err := os.Open(dirname)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
 // delete the given card from the VDir
+// the card is moved to the trash and can be restored from there.
 func (b Addressbook) Delete(card vdir.Card) error {
 	path := b.cardPath(card)
-	// TODO: move to trash
-	return os.Remove(path)
+	err := b.Trash().Put(path)
+	if err == nil {
+		delete(b.paths, card.Uid)
+	}
+	return err
+}
+
+// The trash for this address book
+// is kept in a hidden subdirectory of the VDir.
+func (b Addressbook) Trash() Trash {
+	return NewTrash(filepath.Join(b.Dirname, ".trash"))
 }
 
 func (b *Addressbook) load() error {
@@ -98,12 +513,24 @@ func (b *Addressbook) load() error {
 	}
 
 	cards := []vdir.Card{}
+	b.errors = []LoadError{}
+	if b.stamps == nil {
+		b.stamps = make(map[string]stamp)
+	}
+	b.paths = make(map[string]string)
 	for _, file := range files {
 		if file.Mode().IsRegular() {
 			if filepath.Ext(file.Name()) == ".vcf" {
-				card, err := loadCard(filepath.Join(b.Dirname, file.Name()))
+				path := filepath.Join(b.Dirname, file.Name())
+				card, st, err := readCard(path)
 				if err != nil {
-					return err
+					log.Printf("Skip %s: %v", path, err)
+					b.errors = append(b.errors, newLoadError(path, err))
+					continue
+				}
+				b.stamps[path] = st
+				if card.Uid != "" {
+					b.paths[card.Uid] = path
 				}
 				cards = append(cards, *card)
 			}
@@ -114,24 +541,100 @@ func (b *Addressbook) load() error {
 }
 
 func loadCard(fullpath string) (*vdir.Card, error) {
+	card, _, err := readCard(fullpath)
+	return card, err
+}
+
+// load a card and remember the state of the file it was read from
+func readCard(fullpath string) (*vdir.Card, stamp, error) {
+	var st stamp
 	card := new(vdir.Card)
 	log.Printf("Load from %s", fullpath)
 	data, err := ioutil.ReadFile(fullpath)
 	if err != nil {
-		return card, err
+		return card, st, err
 	}
+	err = unmarshalCard(data, card)
+	if err != nil {
+		return card, st, err
+	}
+	return card, newStamp(fullpath, *card, data), nil
+}
+
+// Parse a single vCard.
+// The data is checked first, so that syntax errors are reported
+// with a line number (see SyntaxError).
+func unmarshalCard(data []byte, card *vdir.Card) (err error) {
+	props, err := parseProperties(data)
+	if err != nil {
+		return err
+	}
+	err = checkSingleCard(props)
+	if err != nil {
+		return err
+	}
+	// vCard 2.1 and 3.0 may have quoted-printable or base64 values
+	// and other charsets
+	if encodedProperties(props) {
+		props, err = decodeProperties(props)
+		if err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		err = writeProperties(&buf, props)
+		if err != nil {
+			return err
+		}
+		data = buf.Bytes()
+	}
+
+	// vdir panics on some malformed input
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Invalid vCard: %v", r)
+		}
+	}()
 	// Unmarshal will panic if file does not end with empty an line
 	// additional empty lines have no effect
-	data = append(data, '\n')
-	err = vdir.Unmarshal(data, card)
-	if err != nil {
-		return card, err
+	return vdir.Unmarshal(append(data, '\n'), card)
+}
+
+func newLoadError(path string, err error) LoadError {
+	line := 0
+	if syntaxErr, ok := err.(*SyntaxError); ok {
+		line = syntaxErr.Line
+		err = errors.New(syntaxErr.Msg)
 	}
-	return card, nil
+	return LoadError{path, line, err}
 }
 
+// The file of a card: the one it was loaded from,
+// for new cards a file name derived from the UID.
 func (b Addressbook) cardPath(card vdir.Card) string {
-	return filepath.Join(b.Dirname, card.Uid+".vcf")
+	if path, ok := b.paths[card.Uid]; ok && card.Uid != "" {
+		return path
+	}
+	return filepath.Join(b.Dirname, cardFilename(card.Uid))
+}
+
+func (b *Addressbook) setPath(uid, path string) {
+	if b.paths == nil {
+		b.paths = make(map[string]string)
+	}
+	b.paths[uid] = path
+}
+
+// The file name for a new card is its UID.
+// UIDs that are not safe as a file name, e.g. "../x" from an imported file,
+// are hashed (like vdirsyncer does).
+func cardFilename(uid string) string {
+	if uid == "" || len(uid) > 200 || strings.HasPrefix(uid, ".") ||
+		strings.Contains(uid, "..") || strings.ContainsAny(uid, "/\\") ||
+		strings.IndexFunc(uid, unicode.IsControl) >= 0 {
+		sum := sha1.Sum([]byte(uid))
+		return hex.EncodeToString(sum[:]) + ".vcf"
+	}
+	return uid + ".vcf"
 }
 
 // Sort Helper
@@ -150,9 +653,17 @@ func (a ByName) Less(i, j int) bool {
 }
 
 // search helper
+// Use ParseQuery to create a Query from a query expression,
+// otherwise Term is used as a plain search term.
 type Query struct {
 	Term       string
 	Categories []string
+	// Strict disables Unicode normalization and diacritic folding,
+	// terms only match if they are equal except for case.
+	Strict bool
+	// Region is used to normalize phone numbers without country code.
+	Region string
+	expr   expr
 }
 
 func (q Query) Matches(card vdir.Card) bool {
@@ -161,14 +672,22 @@ func (q Query) Matches(card vdir.Card) bool {
 	if len(q.Categories) > 0 {
 		categoryMatch = q.matchCategories(card)
 	}
-	termMatch := q.matchTerm(card)
+	var termMatch bool
+	if q.expr != nil {
+		termMatch = q.expr.eval(q, card)
+	} else {
+		termMatch = q.matchTerm(card, q.Term)
+	}
 	return categoryMatch && termMatch
 }
 
 func (q Query) matchCategories(card vdir.Card) bool {
+	if len(q.Categories) == 0 {
+		return true
+	}
 	for _, requested := range q.Categories {
 		for _, present := range card.Categories {
-			if strings.ToLower(requested) == strings.ToLower(present) {
+			if q.equal(requested, present) {
 				return true
 			}
 		}
@@ -176,45 +695,57 @@ func (q Query) matchCategories(card vdir.Card) bool {
 	return false
 }
 
-func (q Query) matchTerm(card vdir.Card) bool {
-	if contains(card.FormattedName, q.Term) {
+func (q Query) matchTerm(card vdir.Card, term string) bool {
+	if q.contains(card.FormattedName, term) {
 		return true
 	}
-	if arrayContains(card.NickName, q.Term) {
+	if q.arrayContains(card.NickName, term) {
 		return true
 	}
-	if arrayContains(card.Name.FamilyName, q.Term) {
+	if q.arrayContains(card.Name.FamilyName, term) {
 		return true
 	}
-	if arrayContains(card.Name.GivenName, q.Term) {
+	if q.arrayContains(card.Name.GivenName, term) {
 		return true
 	}
-	if typedValuesContain(card.Email, q.Term) {
+	if q.typedValuesContain(card.Email, term) {
 		return true
 	}
-	if typedValuesContain(card.Telephones, q.Term) {
+	if q.matchPhones(card.Telephones, term) {
 		return true
 	}
 
 	return false
 }
 
-func contains(s, q string) bool {
-	return strings.Contains(strings.ToLower(s), strings.ToLower(q))
+func (q Query) contains(s, sub string) bool {
+	return strings.Contains(q.normalize(s), q.normalize(sub))
+}
+
+func (q Query) equal(a, b string) bool {
+	return q.normalize(a) == q.normalize(b)
+}
+
+// prepare a string for comparison
+func (q Query) normalize(s string) string {
+	if q.Strict {
+		return strings.ToLower(s)
+	}
+	return foldString(s)
 }
 
-func typedValuesContain(tvalues []vdir.TypedValue, query string) bool {
+func (q Query) typedValuesContain(tvalues []vdir.TypedValue, query string) bool {
 	for _, tv := range tvalues {
-		if contains(tv.Value, query) {
+		if q.contains(tv.Value, query) {
 			return true
 		}
 	}
 	return false
 }
 
-func arrayContains(texts []string, query string) bool {
+func (q Query) arrayContains(texts []string, query string) bool {
 	for _, s := range texts {
-		if contains(s, query) {
+		if q.contains(s, query) {
 			return true
 		}
 	}
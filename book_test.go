@@ -0,0 +1,182 @@
+package contacts
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/xconstruct/vdir"
+)
+
+func TestWriteFileAtomicFailure(t *testing.T) {
+	failWrite := func(file *os.File, data []byte, mode os.FileMode) error {
+		file.Write(data[:len(data)/2])
+		file.Close()
+		return errors.New("disk full")
+	}
+	failRename := func(oldpath, newpath string) error {
+		return errors.New("rename failed")
+	}
+
+	tests := []struct {
+		name   string
+		write  func(*os.File, []byte, os.FileMode) error
+		rename func(string, string) error
+	}{
+		{"write", failWrite, os.Rename},
+		{"rename", writeAndSync, failRename},
+	}
+	defer func() {
+		writeTempFile = writeAndSync
+		renameFile = os.Rename
+	}()
+
+	for _, test := range tests {
+		dir, err := ioutil.TempDir("", "contacts-test-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "1234.vcf")
+		original := []byte("BEGIN:VCARD\r\nVERSION:4.0\r\nFN:John Doe\r\nEND:VCARD\r\n")
+		err = ioutil.WriteFile(path, original, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		writeTempFile = test.write
+		renameFile = test.rename
+		err = writeFileAtomic(path, []byte("BEGIN:VCARD\r\nFN:Jane Doe\r\nEND:VCARD\r\n"))
+		if err == nil {
+			t.Errorf("%v: expected an error", test.name)
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != string(original) {
+			t.Errorf("%v: file changed to %q", test.name, data)
+		}
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) != 1 {
+			for _, file := range files {
+				t.Errorf("%v: left in directory: %v", test.name, file.Name())
+			}
+		}
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "contacts-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "1234.vcf")
+	err = ioutil.WriteFile(path, []byte("old"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = writeFileAtomic(path, []byte("new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadFile(path)
+	if string(data) != "new" {
+		t.Errorf("Expected %q, got %q", "new", data)
+	}
+	info, _ := os.Stat(path)
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected mode 0600, got %v", info.Mode().Perm())
+	}
+}
+
+func TestCardPath(t *testing.T) {
+	book := NewAddressbook("/home/user/contacts")
+	tests := []struct {
+		uid    string
+		hashed bool
+	}{
+		{"8a7bd7c6-3c5e-4cb3-9c28-8d8f0e5e1b4d", false},
+		{"urn:uuid:8a7bd7c6-3c5e-4cb3-9c28-8d8f0e5e1b4d", false},
+		{"john.doe@example.com", false},
+		{"../../.bashrc", true},
+		{"a/b", true},
+		{"a\\b", true},
+		{"..", true},
+		{".hidden", true},
+		{"line\nbreak", true},
+		{"", true},
+	}
+	for _, test := range tests {
+		path := book.cardPath(vdir.Card{Uid: test.uid})
+		if filepath.Dir(path) != book.Dirname {
+			t.Errorf("UID %q: %v is not in %v", test.uid, path, book.Dirname)
+		}
+		plain := filepath.Base(path) == test.uid+".vcf"
+		if plain == test.hashed {
+			t.Errorf("UID %q: unexpected file name %v", test.uid, filepath.Base(path))
+		}
+	}
+}
+
+// cards are saved to the file they were loaded from,
+// even if a new card with that UID would get another file name
+func TestLoadedCardPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "contacts-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// e.g. written by vdirsyncer or an older version
+	uid := "a..b"
+	path := filepath.Join(dir, uid+".vcf")
+	data := "BEGIN:VCARD\r\nVERSION:3.0\r\nFN:John Doe\r\nUID:" + uid + "\r\nEND:VCARD\r\n"
+	err = ioutil.WriteFile(path, []byte(data), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	book := NewAddressbook(dir)
+	cards, err := book.Find(Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cards) != 1 || cards[0].Uid != uid {
+		t.Fatalf("Expected the card %v, got %v", uid, cards)
+	}
+	card := cards[0]
+	if book.cardPath(card) != path {
+		t.Errorf("Expected path %v, got %v", path, book.cardPath(card))
+	}
+
+	conflict, err := book.CheckModified(card)
+	if err != nil || conflict != nil {
+		t.Errorf("Expected no conflict, got %v, %v", conflict, err)
+	}
+	card.Org = "ACME"
+	err = book.Save(card)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.vcf"))
+	if len(files) != 1 || files[0] != path {
+		t.Errorf("Expected only %v, got %v", path, files)
+	}
+
+	err = book.Delete(card)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected %v to be deleted, got %v", path, err)
+	}
+}
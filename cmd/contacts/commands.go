@@ -10,6 +10,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/alecthomas/kingpin"
 	"github.com/xconstruct/vdir"
@@ -20,17 +21,77 @@ import (
 // Controller -----------------------------------------------------------------
 
 type controller struct {
-	term       string
+	terms      []string
 	categories string
 	firstName  string
 	lastName   string
 	nickName   string
 	skipEdit   bool
-	format	   string
+	format     string
+	olderThan  string
+	phones     bool
+	dryRun     bool
+	style      string
+	filename   string
+	profile    string
+	mapping    string
+	columns    string
+	expand     bool
+	strip      string
+	private    bool
+	version    string
+	ics        bool
+	alarm      int
+	days       int
+	book       string
+	target     string
+	newUID     bool
+	json       bool
 }
 
-func (c *controller) query() contacts.Query {
-	return contacts.Query{c.term, normalizedSplit(c.categories)}
+func (c *controller) query(cfg contacts.Configuration) (contacts.Query, error) {
+	term := strings.Join(c.terms, " ")
+	query, err := contacts.ParseQuery(term, normalizedSplit(c.categories))
+	query.Strict = cfg.Matching == "strict"
+	query.Region = cfg.PhoneRegion
+	return query, err
+}
+
+func openAddressbook(cfg contacts.Configuration, name string) (*contacts.Addressbook, error) {
+	path, err := cfg.BookPath(name)
+	if err != nil {
+		return nil, err
+	}
+	book := contacts.NewAddressbook(path)
+	book.Name = name
+	book.Version = cfg.Version
+	return book, nil
+}
+
+// the address book given with --book, or the default book
+func (c *controller) addressbook(cfg contacts.Configuration) (*contacts.Addressbook, error) {
+	name := c.book
+	if name == "" {
+		name = cfg.DefaultBook
+	}
+	return openAddressbook(cfg, name)
+}
+
+// the address book given with --book, or all address books
+func (c *controller) addressbooks(cfg contacts.Configuration) (contacts.Addressbooks, error) {
+	names := cfg.BookNames()
+	if c.book != "" {
+		names = []string{c.book}
+	}
+	books := contacts.Addressbooks{}
+	for _, name := range names {
+		book, err := openAddressbook(cfg, name)
+		if err != nil {
+			return nil, err
+		}
+		books = append(books, book)
+	}
+	return books, nil
 }
 
 func normalizedSplit(s string) []string {
@@ -39,7 +100,7 @@ func normalizedSplit(s string) []string {
 	for _, part := range parts {
 		x := strings.TrimSpace(part)
 		if x != "" {
-			result = append(result, part)
+			result = append(result, x)
 		}
 	}
 	return result
@@ -57,7 +118,10 @@ func (c *controller) card() vdir.Card {
 func (c *controller) add(unused *kingpin.ParseContext) error {
 	var err error
 	cfg := contacts.ReadConfiguration()
-	book := contacts.NewAddressbook(cfg.Addressbook)
+	book, err := c.addressbook(cfg)
+	if err != nil {
+		return err
+	}
 	card := c.card()
 	if !c.skipEdit {
 		_, err = contacts.EditCard(cfg, &card)
@@ -78,40 +142,103 @@ func (c *controller) add(unused *kingpin.ParseContext) error {
 // Use an empty query to list all contacts.
 func (c *controller) list(unused *kingpin.ParseContext) error {
 	cfg := contacts.ReadConfiguration()
-	book := contacts.NewAddressbook(cfg.Addressbook)
-	results, err := book.Find(c.query())
+	books, err := c.addressbooks(cfg)
 	if err != nil {
 		return err
-	} else if len(results) == 0 {
+	}
+	query, err := c.query(cfg)
+	if err != nil {
+		return err
+	}
+	matches, err := books.Rank(query)
+	if err != nil {
+		return err
+	}
+	warnLoadErrors(books)
+	results := contacts.ExactMatches(matches)
+	opts := contacts.ListOptions{
+		Format:  c.format,
+		Columns: normalizedSplit(c.columns),
+		Expand:  c.expand,
+		Books:   len(books) > 1,
+	}
+	err = contacts.CheckCSVColumns(opts.Columns)
+	if err != nil {
+		return err
+	}
+	machine := c.format == "json" || c.format == "ndjson" || c.format == "csv"
+	if len(results) == 0 && !machine {
 		fmt.Println("No match.")
+		// similar cards are only suggested, see Query.Score
+		if len(matches) > 0 {
+			names := []string{}
+			for _, match := range matches {
+				names = append(names, displayName(match.Card))
+			}
+			fmt.Printf("Did you mean: %v?\n", strings.Join(names, ", "))
+		}
 		return nil
 	}
-	contacts.ShowList(results, c.format)
-	return nil
+	return contacts.ShowList(results, opts)
+}
+
+// check all cards in the address book and report those that cannot be read
+func (c *controller) check(unused *kingpin.ParseContext) error {
+	cfg := contacts.ReadConfiguration()
+	books, err := c.addressbooks(cfg)
+	if err != nil {
+		return err
+	}
+	loadErrors, err := books.LoadErrors()
+	if err != nil {
+		return err
+	} else if len(loadErrors) == 0 {
+		fmt.Println("All contacts OK.")
+		return nil
+	}
+	for _, loadErr := range loadErrors {
+		fmt.Println(loadErr)
+	}
+	return fmt.Errorf("%v broken file(s).", len(loadErrors))
 }
 
 // show details for a single contact that matches the given `query`.
-// If multiple contacts match, user selects one.
+// If multiple contacts match and none is clearly the best, user selects one.
 func (c *controller) show(unused *kingpin.ParseContext) error {
 	cfg := contacts.ReadConfiguration()
-	book := contacts.NewAddressbook(cfg.Addressbook)
-	card, err := selectOne(book, c.query())
+	books, err := c.addressbooks(cfg)
 	if err != nil {
 		return err
 	}
-	return contacts.ShowDetails(card)
+	query, err := c.query(cfg)
+	if err != nil {
+		return err
+	}
+	match, err := selectBest(books, query)
+	if err != nil {
+		return err
+	}
+	return contacts.ShowCard(match, c.format)
 }
 
 // edit details for a single contact that matches the given `query`.
 // If multiple contacts match, user selects one.
 func (c *controller) edit(unused *kingpin.ParseContext) error {
 	cfg := contacts.ReadConfiguration()
-	book := contacts.NewAddressbook(cfg.Addressbook)
-	card, err := selectOne(book, c.query())
+	books, err := c.addressbooks(cfg)
+	if err != nil {
+		return err
+	}
+	query, err := c.query(cfg)
+	if err != nil {
+		return err
+	}
+	match, err := selectOne(books, query)
 	if err != nil {
 		return err
 	}
 
+	card := match.Card
 	modified, err := contacts.EditCard(cfg, &card)
 	if err != nil {
 		return err
@@ -121,9 +248,12 @@ func (c *controller) edit(unused *kingpin.ParseContext) error {
 		return nil
 	}
 
-	err = book.Save(card)
+	saved, err := saveChecked(cfg, match.Book, &card)
 	if err != nil {
 		return err
+	} else if !saved {
+		fmt.Println("Changes discarded.")
+		return nil
 	}
 
 	fmt.Println("Contact saved.")
@@ -133,63 +263,856 @@ func (c *controller) edit(unused *kingpin.ParseContext) error {
 // delete a contact
 func (c *controller) del(unused *kingpin.ParseContext) error {
 	cfg := contacts.ReadConfiguration()
-	book := contacts.NewAddressbook(cfg.Addressbook)
-	card, err := selectOne(book, c.query())
+	books, err := c.addressbooks(cfg)
+	if err != nil {
+		return err
+	}
+	query, err := c.query(cfg)
+	if err != nil {
+		return err
+	}
+	match, err := selectOne(books, query)
 	if err != nil {
 		return err
 	}
 
-	err = book.Delete(card)
+	err = match.Book.Delete(match.Card)
 	if err == nil {
 		fmt.Println("Contact deleted.")
 	}
 	return err
 }
 
+// move a contact to another address book
+func (c *controller) move(unused *kingpin.ParseContext) error {
+	cfg := contacts.ReadConfiguration()
+	books, err := c.addressbooks(cfg)
+	if err != nil {
+		return err
+	}
+	target, err := openAddressbook(cfg, c.target)
+	if err != nil {
+		return err
+	}
+	query, err := c.query(cfg)
+	if err != nil {
+		return err
+	}
+	match, err := selectOne(books, query)
+	if err != nil {
+		return err
+	}
+
+	err = match.Book.Move(match.Card, target)
+	if err == nil {
+		fmt.Printf("Contact moved to %v.\n", target.Name)
+	}
+	return err
+}
+
+// copy a contact to another address book
+func (c *controller) copy(unused *kingpin.ParseContext) error {
+	cfg := contacts.ReadConfiguration()
+	books, err := c.addressbooks(cfg)
+	if err != nil {
+		return err
+	}
+	target, err := openAddressbook(cfg, c.target)
+	if err != nil {
+		return err
+	}
+	query, err := c.query(cfg)
+	if err != nil {
+		return err
+	}
+	match, err := selectOne(books, query)
+	if err != nil {
+		return err
+	}
+
+	uid, err := match.Book.Copy(match.Card, target, c.newUID)
+	if err != nil {
+		return err
+	}
+	log.Printf("Copied %v to %v", displayName(match.Card), uid)
+	fmt.Printf("Contact copied to %v.\n", target.Name)
+	return nil
+}
+
+// merge several contacts into one.
+// The merged contact replaces the first selected one,
+// the others are moved to the trash.
+func (c *controller) merge(unused *kingpin.ParseContext) error {
+	cfg := contacts.ReadConfiguration()
+	books, err := c.addressbooks(cfg)
+	if err != nil {
+		return err
+	}
+	query, err := c.query(cfg)
+	if err != nil {
+		return err
+	}
+	found, err := books.Rank(query)
+	if err != nil {
+		return err
+	}
+	// similar contacts only if the user agrees
+	exact := contacts.ExactMatches(found)
+	if len(exact) < len(found) {
+		names := []string{}
+		for _, match := range found {
+			if match.Fuzzy {
+				names = append(names, displayName(match.Card))
+			}
+		}
+		answer, err := ask(fmt.Sprintf("Also offer similar contacts (%v)? (y/n) ",
+			strings.Join(names, ", ")), "yn")
+		if err != nil {
+			return err
+		} else if answer == 'n' {
+			found = exact
+		}
+	}
+	if len(found) < 2 {
+		return errors.New("Need at least two contacts to merge.")
+	}
+	selected, err := chooseMany(found, len(books) > 1)
+	if err != nil {
+		return err
+	} else if len(selected) < 2 {
+		return errors.New("Select at least two contacts to merge.")
+	}
+	_, err = mergeMatches(cfg, selected)
+	return err
+}
+
+// find likely duplicates and offer to merge or delete them
+func (c *controller) dedup(unused *kingpin.ParseContext) error {
+	cfg := contacts.ReadConfiguration()
+	books, err := c.addressbooks(cfg)
+	if err != nil {
+		return err
+	}
+	query, err := c.query(cfg)
+	if err != nil {
+		return err
+	}
+	matches, err := books.Rank(query)
+	if err != nil {
+		return err
+	}
+	warnLoadErrors(books)
+	groups := contacts.FindDuplicates(contacts.ExactMatches(matches), cfg.PhoneRegion)
+	if c.json {
+		return contacts.WriteDuplicatesJSON(os.Stdout, groups)
+	}
+	if len(groups) == 0 {
+		fmt.Println("No duplicates found.")
+		return nil
+	}
+
+	// cards that were merged or deleted in an earlier group
+	handled := map[string]bool{}
+	key := func(match contacts.Match) string {
+		return match.Book.Dirname + "/" + match.Card.Uid
+	}
+	for i, group := range groups {
+		remaining := []contacts.Match{}
+		for _, match := range group.Cards {
+			if !handled[key(match)] {
+				remaining = append(remaining, match)
+			}
+		}
+		if len(remaining) < 2 {
+			continue
+		}
+		group.Cards = remaining
+		fmt.Printf("\n%v/%v: %.0f%% likely duplicates (%v)\n", i+1, len(groups),
+			group.Confidence*100, strings.Join(group.Reasons, ", "))
+		for _, match := range group.Cards {
+			label := displayName(match.Card)
+			if mail := contacts.PrimaryMail(match.Card); mail != "" {
+				label += " <" + mail + ">"
+			}
+			if len(books) > 1 {
+				label = fmt.Sprintf("%v (%v)", label, match.Book.Name)
+			}
+			fmt.Println("  " + label)
+		}
+		answer, err := ask("(m)erge, (d)elete one, (s)kip or (q)uit? ", "mdsq")
+		if err != nil {
+			return err
+		}
+		switch answer {
+		case 'm':
+			selected, err := chooseMany(group.Cards, len(books) > 1)
+			if err != nil {
+				return err
+			} else if len(selected) < 2 {
+				return errors.New("Select at least two contacts to merge.")
+			}
+			merged, err := mergeMatches(cfg, selected)
+			if err != nil {
+				return err
+			}
+			if merged {
+				for _, match := range selected {
+					handled[key(match)] = true
+				}
+			}
+		case 'd':
+			match, err := choose(group.Cards, len(books) > 1)
+			if err != nil {
+				return err
+			}
+			err = match.Book.Delete(match.Card)
+			if err != nil {
+				return err
+			}
+			handled[key(match)] = true
+			fmt.Println("Contact deleted.")
+		case 'q':
+			return nil
+		}
+	}
+	return nil
+}
+
+// normalize phone numbers of all contacts (or those matching the query)
+func (c *controller) normalize(unused *kingpin.ParseContext) error {
+	if !c.phones {
+		return errors.New("Nothing to normalize, use --phones.")
+	}
+	cfg := contacts.ReadConfiguration()
+	books, err := c.addressbooks(cfg)
+	if err != nil {
+		return err
+	}
+	query, err := c.query(cfg)
+	if err != nil {
+		return err
+	}
+	matches, err := books.Rank(query)
+	if err != nil {
+		return err
+	}
+
+	count := 0
+	for _, match := range contacts.ExactMatches(matches) {
+		card := match.Card
+		changes := contacts.NormalizePhones(&card, cfg.PhoneRegion, c.style)
+		modified := false
+		for _, change := range changes {
+			if change.Err != nil {
+				fmt.Fprintf(os.Stderr, "%v: %v\n", displayName(card), change.Err)
+				continue
+			}
+			fmt.Printf("%v: %v -> %v\n", displayName(card), change.Old, change.New)
+			modified = true
+		}
+		if modified && !c.dryRun {
+			err = match.Book.Save(card)
+			if err != nil {
+				return err
+			}
+			count++
+		}
+	}
+
+	if c.dryRun {
+		fmt.Println("Dry run, no contacts changed.")
+	} else {
+		fmt.Printf("%v contact(s) changed.\n", count)
+	}
+	return nil
+}
+
+// export contacts matching the query to stdout
+func (c *controller) export(unused *kingpin.ParseContext) error {
+	cfg := contacts.ReadConfiguration()
+	books, err := c.addressbooks(cfg)
+	if err != nil {
+		return err
+	}
+	query, err := c.query(cfg)
+	if err != nil {
+		return err
+	}
+	ranked, err := books.Rank(query)
+	if err != nil {
+		return err
+	}
+	matches := contacts.ExactMatches(ranked)
+	sort.SliceStable(matches, func(i, j int) bool {
+		return contacts.FormatName(matches[i].Card) < contacts.FormatName(matches[j].Card)
+	})
+	opts := contacts.ExportOptions{
+		Strip:   normalizedSplit(c.strip),
+		Version: c.version,
+	}
+	if !c.private {
+		opts.Strip = append(opts.Strip, contacts.PrivateProperties...)
+	}
+	return contacts.WriteCards(os.Stdout, matches, c.format, opts)
+}
+
+// import contacts from a file or stdin
+func (c *controller) importCards(unused *kingpin.ParseContext) error {
+	reader := os.Stdin
+	if c.filename != "" && c.filename != "-" {
+		file, err := os.Open(c.filename)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		reader = file
+	}
+
+	opts := contacts.ImportOptions{Profile: c.profile}
+	if c.mapping != "" {
+		mapping, err := contacts.ReadCSVMapping(c.mapping)
+		if err != nil {
+			return err
+		}
+		opts.Mapping = mapping
+	}
+
+	cfg := contacts.ReadConfiguration()
+	book, err := c.addressbook(cfg)
+	if err != nil {
+		return err
+	}
+	cards, failed, err := contacts.ReadCards(reader, c.format, opts)
+	if err != nil {
+		return err
+	}
+	for _, err := range failed {
+		fmt.Fprintln(os.Stderr, err)
+	}
+
+	// cards without UID get one when they are saved
+	created, skipped := 0, 0
+	seen := map[string]bool{}
+	for _, card := range cards {
+		if card.Uid != "" {
+			exists, err := book.Contains(card.Uid)
+			if err != nil {
+				return err
+			}
+			if exists || seen[card.Uid] {
+				log.Printf("Skip %v, UID %v exists", displayName(card.Card), card.Uid)
+				skipped++
+				continue
+			}
+			seen[card.Uid] = true
+		}
+		if c.dryRun {
+			err = contacts.ShowDetails(card.Card)
+		} else {
+			err = book.Import(card)
+		}
+		if err != nil {
+			return err
+		}
+		created++
+	}
+
+	if c.dryRun {
+		fmt.Printf("Dry run, would create %v, skip %v duplicate(s), %v failed.\n",
+			created, skipped, len(failed))
+	} else {
+		fmt.Printf("Created %v, skipped %v duplicate(s), %v failed.\n",
+			created, skipped, len(failed))
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%v contact(s) could not be imported.", len(failed))
+	}
+	return nil
+}
+
+// convert all cards to another vCard version
+func (c *controller) convert(unused *kingpin.ParseContext) error {
+	cfg := contacts.ReadConfiguration()
+	books, err := c.addressbooks(cfg)
+	if err != nil {
+		return err
+	}
+	var converted []string
+	var failed []contacts.LoadError
+	for _, book := range books {
+		paths, loadErrors, err := book.Convert(c.version, c.dryRun)
+		if err != nil {
+			return err
+		}
+		converted = append(converted, paths...)
+		failed = append(failed, loadErrors...)
+	}
+	for _, path := range converted {
+		fmt.Println(path)
+	}
+	for _, err := range failed {
+		fmt.Fprintln(os.Stderr, err)
+	}
+
+	if c.dryRun {
+		fmt.Printf("Dry run, would convert %v contact(s) to vCard %v.\n",
+			len(converted), c.version)
+	} else {
+		fmt.Printf("Converted %v contact(s) to vCard %v.\n", len(converted), c.version)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%v contact(s) could not be converted.", len(failed))
+	}
+	return nil
+}
+
+// list upcoming birthdays and anniversaries
+// or export birthdays as a calendar
+func (c *controller) birthdays(unused *kingpin.ParseContext) error {
+	cfg := contacts.ReadConfiguration()
+	books, err := c.addressbooks(cfg)
+	if err != nil {
+		return err
+	}
+	query, err := c.query(cfg)
+	if err != nil {
+		return err
+	}
+	cards, err := books.Find(query)
+	if err != nil {
+		return err
+	}
+	sort.Sort(contacts.ByName(cards))
+	now := time.Now()
+	if c.ics {
+		return contacts.WriteBirthdayCalendar(os.Stdout, cards, c.alarm, now)
+	}
+	occasions := contacts.Upcoming(cards, c.days, now)
+	if len(occasions) == 0 {
+		fmt.Printf("No birthdays in the next %v days.\n", c.days)
+		return nil
+	}
+	contacts.ShowUpcoming(occasions, now)
+	return nil
+}
+
+// query contacts for mutt's query_command
+func (c *controller) muttQuery(unused *kingpin.ParseContext) error {
+	cfg := contacts.ReadConfiguration()
+	books, err := c.addressbooks(cfg)
+	if err != nil {
+		return err
+	}
+	query, err := c.query(cfg)
+	if err != nil {
+		return err
+	}
+	cards, err := books.Find(query)
+	if err != nil {
+		return err
+	}
+	if len(cards) == 0 {
+		// mutt shows the first line and expects a non-zero exit code
+		fmt.Println("No match.")
+		os.Exit(1)
+	}
+	return contacts.WriteMuttQuery(os.Stdout, cards)
+}
+
+// print a mutt alias file
+func (c *controller) muttAliases(unused *kingpin.ParseContext) error {
+	cfg := contacts.ReadConfiguration()
+	books, err := c.addressbooks(cfg)
+	if err != nil {
+		return err
+	}
+	query, err := c.query(cfg)
+	if err != nil {
+		return err
+	}
+	cards, err := books.Find(query)
+	if err != nil {
+		return err
+	}
+	aliases, collisions := contacts.MuttAliases(cards)
+	for _, msg := range collisions {
+		fmt.Fprintln(os.Stderr, msg)
+	}
+	for _, alias := range aliases {
+		fmt.Println(alias)
+	}
+	return nil
+}
+
+// list the contents of the trash
+func (c *controller) trashList(unused *kingpin.ParseContext) error {
+	cfg := contacts.ReadConfiguration()
+	books, err := c.addressbooks(cfg)
+	if err != nil {
+		return err
+	}
+	items := []contacts.TrashedCard{}
+	for _, book := range books {
+		trashed, err := book.Trash().List()
+		if err != nil {
+			return err
+		}
+		items = append(items, trashed...)
+	}
+	if len(items) == 0 {
+		fmt.Println("Trash is empty.")
+		return nil
+	}
+	contacts.ShowTrash(items)
+	return nil
+}
+
+// restore a single contact from the trash.
+// If multiple trashed contacts match, user selects one.
+func (c *controller) trashRestore(unused *kingpin.ParseContext) error {
+	cfg := contacts.ReadConfiguration()
+	books, err := c.addressbooks(cfg)
+	if err != nil {
+		return err
+	}
+	query, err := c.query(cfg)
+	if err != nil {
+		return err
+	}
+
+	// the trash of each item, to restore it into the right address book
+	found := []contacts.TrashedCard{}
+	trashes := []contacts.Trash{}
+	for _, book := range books {
+		trash := book.Trash()
+		items, err := trash.List()
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			if query.Matches(item.Card) {
+				found = append(found, item)
+				trashes = append(trashes, trash)
+			}
+		}
+	}
+
+	index := 0
+	if len(found) > 1 {
+		labels := []string{}
+		for _, item := range found {
+			labels = append(labels, fmt.Sprintf("%v (deleted %v)",
+				displayName(item.Card),
+				item.DeletionDate.Local().Format(time.RFC822)))
+		}
+		index, err = chooseIndex(labels)
+		if err != nil {
+			return err
+		}
+	} else if len(found) == 0 {
+		return errors.New("No match.")
+	}
+
+	err = trashes[index].Restore(found[index])
+	if err == nil {
+		fmt.Println("Contact restored.")
+	}
+	return err
+}
+
+// permanently delete old contacts from the trash
+func (c *controller) trashPurge(unused *kingpin.ParseContext) error {
+	age, err := parseAge(c.olderThan)
+	if err != nil {
+		return err
+	}
+	cfg := contacts.ReadConfiguration()
+	books, err := c.addressbooks(cfg)
+	if err != nil {
+		return err
+	}
+	count := 0
+	for _, book := range books {
+		n, err := book.Trash().Purge(age)
+		if err != nil {
+			return err
+		}
+		count += n
+	}
+	fmt.Printf("Purged %v contact(s).\n", count)
+	return nil
+}
+
 // Helpers --------------------------------------------------------------------
 
-func selectOne(book *contacts.Addressbook, query contacts.Query) (vdir.Card, error) {
-	var selected vdir.Card
-	found, err := book.Find(query)
+// select a single contact, the user chooses if several match.
+// Similar contacts are only offered if none matches exactly,
+// a single similar contact must be confirmed.
+func selectOne(books contacts.Addressbooks, query contacts.Query) (contacts.Match, error) {
+	var selected contacts.Match
+	found, err := books.Rank(query)
 	if err != nil {
 		return selected, err
 	}
+	if exact := contacts.ExactMatches(found); len(exact) > 0 {
+		found = exact
+	}
 
 	if len(found) > 1 {
-		selected, err = choose(found)
+		selected, err = choose(found, len(books) > 1)
 	} else if len(found) == 1 {
 		selected = found[0]
+		if selected.Fuzzy {
+			answer, err := ask(fmt.Sprintf("No exact match, did you mean %v? (y/n) ",
+				displayName(selected.Card)), "yn")
+			if err != nil {
+				return selected, err
+			} else if answer == 'n' {
+				return contacts.Match{}, errors.New("No match.")
+			}
+		}
 	} else {
 		err = errors.New("No match.")
 	}
 	return selected, err
 }
 
-func choose(choices []vdir.Card) (vdir.Card, error) {
-	var chosen vdir.Card
-	sort.Sort(contacts.ByName(choices))
+// like selectOne, but do not ask if one match is clearly better than the others
+func selectBest(books contacts.Addressbooks, query contacts.Query) (contacts.Match, error) {
+	matches, err := books.Rank(query)
+	if err != nil {
+		return contacts.Match{}, err
+	} else if contacts.Dominant(matches) {
+		return matches[0], nil
+	}
+	return selectOne(books, query)
+}
+
+// merge the selected cards into the first one and trash the others,
+// the user resolves conflicts in the editor and confirms the result.
+// Returns `true` if the cards were merged.
+func mergeMatches(cfg contacts.Configuration, selected []contacts.Match) (bool, error) {
+	cards := []vdir.Card{}
+	for _, match := range selected {
+		cards = append(cards, match.Card)
+	}
+	merged, conflicts := contacts.MergeCards(cards)
+	if len(conflicts) > 0 {
+		_, err := contacts.EditMerge(cfg, &merged, conflicts)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	err := contacts.ShowDetails(merged)
+	if err != nil {
+		return false, err
+	}
+	answer, err := ask(fmt.Sprintf("Save and delete the other %v contact(s)? (y/n) ",
+		len(selected)-1), "yn")
+	if err != nil {
+		return false, err
+	} else if answer == 'n' {
+		fmt.Println("Not merged.")
+		return false, nil
+	}
+
+	kept := selected[0]
+	saved, err := saveChecked(cfg, kept.Book, &merged)
+	if err != nil {
+		return false, err
+	} else if !saved {
+		fmt.Println("Changes discarded.")
+		return false, nil
+	}
+	for _, match := range selected[1:] {
+		err = match.Book.Delete(match.Card)
+		if err != nil {
+			return false, err
+		}
+	}
+	fmt.Printf("Merged %v contacts.\n", len(selected))
+	return true, nil
+}
+
+// save a card that was edited,
+// but check for changes made by someone else in the meantime first.
+// On a conflict, the user decides what to do.
+// Returns `false` if the changes were discarded.
+func saveChecked(cfg contacts.Configuration, book *contacts.Addressbook, card *vdir.Card) (bool, error) {
+	for {
+		conflict, err := book.CheckModified(*card)
+		if err != nil {
+			return false, err
+		} else if conflict == nil {
+			return true, book.Save(*card)
+		}
+
+		if conflict.Err != nil {
+			// maybe still being written, e.g. by vdirsyncer;
+			// keep the edited card until the user decides
+			fmt.Printf("The contact was changed while you were editing and cannot be read: %v\n",
+				conflict.Err)
+			answer, err := ask("(o)verwrite or (r)etry? ", "or")
+			if err != nil {
+				return false, err
+			} else if answer == 'o' {
+				return true, book.Save(*card)
+			}
+			continue
+		}
+
+		if conflict.Theirs == nil {
+			fmt.Println("The contact was deleted while you were editing.")
+		} else {
+			fmt.Printf("The contact was modified at %v while you were editing.\n",
+				conflict.ModTime.Format(time.RFC822))
+		}
+		answer, err := ask("(o)verwrite, (d)iscard or (r)eopen editor? ", "odr")
+		if err != nil {
+			return false, err
+		}
+		switch answer {
+		case 'o':
+			return true, book.Save(*card)
+		case 'd':
+			return false, nil
+		case 'r':
+			book.Rebase(conflict)
+			_, err = contacts.EditConflict(cfg, card, conflict.Theirs)
+			if err != nil {
+				return false, err
+			}
+		}
+	}
+}
+
+// ask the user a question until one of the `options` is answered
+func ask(question, options string) (rune, error) {
+	console := bufio.NewReader(os.Stdin)
+	for {
+		fmt.Print(question)
+		input, err := console.ReadString('\n')
+		if err != nil {
+			return 0, err
+		}
+		input = strings.ToLower(strings.TrimSpace(input))
+		if len(input) == 1 && strings.Contains(options, input) {
+			return rune(input[0]), nil
+		}
+	}
+}
+
+// print a warning to stderr if some cards could not be loaded
+func warnLoadErrors(books contacts.Addressbooks) {
+	loadErrors, err := books.LoadErrors()
+	if err == nil && len(loadErrors) > 0 {
+		fmt.Fprintf(os.Stderr,
+			"Warning: %v file(s) could not be read, run `card check` for details.\n",
+			len(loadErrors))
+	}
+}
+
+// let the user select one of the matches,
+// with `books`, the name of the address book is shown.
+func choose(choices []contacts.Match, books bool) (contacts.Match, error) {
+	var chosen contacts.Match
+	labels := []string{}
+	for _, match := range choices {
+		label := displayName(match.Card)
+		if books {
+			label = fmt.Sprintf("%v (%v)", label, match.Book.Name)
+		}
+		labels = append(labels, label)
+	}
+	index, err := chooseIndex(labels)
+	if err != nil {
+		return chosen, err
+	}
+	chosen = choices[index]
+	return chosen, err
+}
+
+// let the user select several of the matches, in the order they are given.
+func chooseMany(choices []contacts.Match, books bool) ([]contacts.Match, error) {
+	fmt.Println("Select contacts, e.g. \"1 3\" or \"all\", the first one is kept:")
+	for i, match := range choices {
+		label := displayName(match.Card)
+		if books {
+			label = fmt.Sprintf("%v (%v)", label, match.Book.Name)
+		}
+		fmt.Printf("%v) %v\n", i+1, label)
+	}
+	fmt.Print("> ")
+	console := bufio.NewReader(os.Stdin)
+	input, err := console.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.TrimSpace(input) == "all" {
+		return choices, nil
+	}
+	selected := []contacts.Match{}
+	seen := map[int]bool{}
+	for _, field := range strings.FieldsFunc(input, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n'
+	}) {
+		index, err := strconv.Atoi(field)
+		if err != nil || index < 1 || index > len(choices) {
+			return nil, errors.New("Invalid selection.")
+		}
+		if !seen[index] {
+			seen[index] = true
+			selected = append(selected, choices[index-1])
+		}
+	}
+	return selected, nil
+}
+
+// let the user select one of the given labels,
+// return the (zero based) index of the selected label.
+func chooseIndex(labels []string) (int, error) {
 	fmt.Println("Select a contact:")
-	for i := 0; i < len(choices); i++ {
+	for i := 0; i < len(labels); i++ {
 		fmt.Print(i + 1)
 		fmt.Print(") ")
-		fmt.Println(displayName(choices[i]))
+		fmt.Println(labels[i])
 	}
 	fmt.Print("> ")
 	console := bufio.NewReader(os.Stdin)
 	input, err := console.ReadString('\n')
 	if err != nil {
-		return chosen, err
+		return -1, err
 	}
 
 	index, err := strconv.ParseInt(strings.TrimSpace(input), 10, 0)
 	if err != nil {
-		return chosen, err
+		return -1, err
+	} else if index < 1 || int(index) > len(labels) {
+		return -1, errors.New("Invalid selection.")
 	}
+	return int(index) - 1, nil
+}
 
-	// TODO check index > 0 and <= len
-	index -= 1
-	chosen = choices[index]
-	return chosen, err
+// parse an age like "30d", "12h" or "90m".
+// In addition to the units known to time.ParseDuration,
+// "d" (days) and "w" (weeks) are accepted.
+func parseAge(s string) (time.Duration, error) {
+	units := map[string]time.Duration{
+		"d": 24 * time.Hour,
+		"w": 7 * 24 * time.Hour,
+	}
+	for suffix, unit := range units {
+		if strings.HasSuffix(s, suffix) {
+			n, err := strconv.Atoi(strings.TrimSuffix(s, suffix))
+			if err != nil || n < 0 {
+				return 0, fmt.Errorf("Invalid age: %v", s)
+			}
+			return time.Duration(n) * unit, nil
+		}
+	}
+	return time.ParseDuration(s)
 }
 
 func displayName(card vdir.Card) string {
@@ -218,7 +1141,7 @@ func catFlag(cmd *kingpin.CmdClause, ctl *controller) {
 }
 
 func queryArg(cmd *kingpin.CmdClause, ctl *controller) {
-	cmd.Arg("query", "Search term.").StringVar(&ctl.term)
+	cmd.Arg("query", "Search term or query expression.").StringsVar(&ctl.terms)
 }
 
 var verbose bool
@@ -236,14 +1159,21 @@ func main() {
 	app := kingpin.New("contacts", "Manage contacts in a VDir.")
 	app.HelpFlag.Short('h') // -h for --help
 	app.Flag("verbose", "Verbose mode.").Short('v').BoolVar(&verbose)
+	app.Flag("book", "Address book to use, default is all books (DefaultBook for new contacts).").
+		Short('b').
+		StringVar(&ctl.book)
 	app.Action(verbosity)
 
 	ls := app.Command("ls", "List contacts").Action(ctl.list)
 	catFlag(ls, ctl)
 	queryArg(ls, ctl)
-	ls.Flag("format", "Output format (default, sup)").
+	ls.Flag("format", "Output format (default, sup, json, ndjson, csv)").
 		Short('f').
 		StringVar(&ctl.format)
+	ls.Flag("columns", "Columns for CSV, e.g. name,email,tel,org,categories.").
+		StringVar(&ctl.columns)
+	ls.Flag("expand", "Put each mail address and phone number in a column of its own (CSV).").
+		BoolVar(&ctl.expand)
 
 	add := app.Command("add", "Add a new contact.").Action(ctl.add)
 	add.Flag("first", "First Name").Short('f').StringVar(&ctl.firstName)
@@ -254,6 +1184,9 @@ func main() {
 	show := app.Command("show", "Show contact details.").Action(ctl.show)
 	catFlag(show, ctl)
 	queryArg(show, ctl)
+	show.Flag("format", "Output format (default, json, ndjson)").
+		Short('f').
+		StringVar(&ctl.format)
 
 	edit := app.Command("edit", "Edit contacts.").Action(ctl.edit)
 	catFlag(edit, ctl)
@@ -263,5 +1196,117 @@ func main() {
 	catFlag(del, ctl)
 	queryArg(del, ctl)
 
+	mv := app.Command("mv", "Move a contact to another address book.").Action(ctl.move)
+	catFlag(mv, ctl)
+	queryArg(mv, ctl)
+	mv.Flag("to", "Name of the target address book.").
+		Required().
+		StringVar(&ctl.target)
+
+	cp := app.Command("cp", "Copy a contact to another address book.").Action(ctl.copy)
+	catFlag(cp, ctl)
+	queryArg(cp, ctl)
+	cp.Flag("to", "Name of the target address book.").
+		Required().
+		StringVar(&ctl.target)
+	cp.Flag("new-uid", "Give the copy a new UID.").BoolVar(&ctl.newUID)
+
+	merge := app.Command("merge", "Merge two or more contacts into one.").Action(ctl.merge)
+	catFlag(merge, ctl)
+	queryArg(merge, ctl)
+
+	dedup := app.Command("dedup", "Find duplicate contacts and merge or delete them.").
+		Action(ctl.dedup)
+	catFlag(dedup, ctl)
+	queryArg(dedup, ctl)
+	dedup.Flag("json", "Print the duplicates as JSON instead of asking.").BoolVar(&ctl.json)
+
+	app.Command("check", "Check for broken contacts.").Action(ctl.check)
+
+	normalize := app.Command("normalize", "Rewrite contact data in a consistent format.").
+		Action(ctl.normalize)
+	catFlag(normalize, ctl)
+	queryArg(normalize, ctl)
+	normalize.Flag("phones", "Normalize phone numbers.").BoolVar(&ctl.phones)
+	normalize.Flag("style", "Phone number format (international, e164, uri).").
+		Default("international").
+		EnumVar(&ctl.style, "international", "e164", "uri")
+	normalize.Flag("dry-run", "Show changes without saving them.").
+		Short('n').
+		BoolVar(&ctl.dryRun)
+
+	export := app.Command("export", "Export contacts.").Action(ctl.export)
+	catFlag(export, ctl)
+	queryArg(export, ctl)
+	export.Flag("format", "Export format (vcf, jcard, xcard, ldif, abook)").
+		Short('f').
+		Default("vcf").
+		EnumVar(&ctl.format, "vcf", "jcard", "xcard", "ldif", "abook")
+	export.Flag("vcard-version", "vCard version for vcf (2.1, 3.0, 4.0).").
+		Default("4.0").
+		EnumVar(&ctl.version, contacts.VCardVersions...)
+	export.Flag("strip", "Properties to leave out, e.g. NOTE,X-*.").
+		StringVar(&ctl.strip)
+	export.Flag("private", "Include private fields (NOTE, BDAY, X-*), use --no-private to leave them out.").
+		Default("true").
+		BoolVar(&ctl.private)
+
+	imp := app.Command("import", "Import contacts.").Action(ctl.importCards)
+	imp.Arg("file", "File to import, default is stdin.").StringVar(&ctl.filename)
+	imp.Flag("format", "Import format (vcf, jcard, xcard, csv, ldif, abook)").
+		Short('f').
+		Default("vcf").
+		EnumVar(&ctl.format, "vcf", "jcard", "xcard", "csv", "ldif", "abook")
+	imp.Flag("profile", "CSV columns (google, outlook, generic).").
+		EnumVar(&ctl.profile, "google", "outlook", "generic")
+	imp.Flag("mapping", "JSON file that maps CSV columns to fields.").
+		StringVar(&ctl.mapping)
+	imp.Flag("dry-run", "Show contacts without saving them.").
+		Short('n').
+		BoolVar(&ctl.dryRun)
+
+	birthdays := app.Command("birthdays", "Show upcoming birthdays and anniversaries.").
+		Action(ctl.birthdays)
+	catFlag(birthdays, ctl)
+	queryArg(birthdays, ctl)
+	birthdays.Flag("days", "Show birthdays in the next N days.").
+		Default("30").
+		IntVar(&ctl.days)
+	birthdays.Flag("ics", "Write an iCalendar file with yearly events.").BoolVar(&ctl.ics)
+	birthdays.Flag("alarm", "Add a reminder N days ahead (with --ics).").
+		Default("0").
+		IntVar(&ctl.alarm)
+
+	muttQuery := app.Command("mutt-query", "Query contacts for mutt's query_command.").
+		Action(ctl.muttQuery)
+	catFlag(muttQuery, ctl)
+	queryArg(muttQuery, ctl)
+
+	muttAliases := app.Command("mutt-aliases", "Print mutt aliases for contacts with a nickname.").
+		Action(ctl.muttAliases)
+	catFlag(muttAliases, ctl)
+	queryArg(muttAliases, ctl)
+
+	convert := app.Command("convert", "Convert all contacts to another vCard version.").
+		Action(ctl.convert)
+	convert.Flag("to", "vCard version (2.1, 3.0, 4.0).").
+		Required().
+		EnumVar(&ctl.version, contacts.VCardVersions...)
+	convert.Flag("dry-run", "Show contacts that would be converted.").
+		Short('n').
+		BoolVar(&ctl.dryRun)
+
+	trash := app.Command("trash", "Manage deleted contacts.")
+	trash.Command("ls", "List deleted contacts.").Action(ctl.trashList)
+	restore := trash.Command("restore", "Restore a deleted contact.").
+		Action(ctl.trashRestore)
+	catFlag(restore, ctl)
+	queryArg(restore, ctl)
+	purge := trash.Command("purge", "Permanently remove old contacts.").
+		Action(ctl.trashPurge)
+	purge.Flag("older-than", "Remove contacts deleted before this age, e.g. 30d.").
+		Default("30d").
+		StringVar(&ctl.olderThan)
+
 	kingpin.MustParse(app.Parse(os.Args[1:]))
 }
@@ -2,17 +2,29 @@ package contacts
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"os"
 	"os/user"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
 type Configuration struct {
 	Addressbook string
+	// Named address books, name -> directory.
+	// If there are none, Addressbook is used with the name "default".
+	Addressbooks map[string]string
+	// The address book for new contacts,
+	// may be left out if there is only one.
+	DefaultBook string
 	Editor      string
+	Matching    string
+	PhoneRegion string
+	Version     string
 }
 
 func ReadConfiguration() Configuration {
@@ -52,14 +64,51 @@ func ReadConfiguration() Configuration {
 	}
 
 	config.Addressbook = replaceHomeDir(config.Addressbook)
+	if len(config.Addressbooks) == 0 {
+		config.Addressbooks = map[string]string{"default": config.Addressbook}
+	}
+	for name, path := range config.Addressbooks {
+		config.Addressbooks[name] = replaceHomeDir(path)
+	}
+	if config.DefaultBook == "" && len(config.Addressbooks) == 1 {
+		config.DefaultBook = config.BookNames()[0]
+	}
 	config.Editor = replaceHomeDir(config.Editor)
 	logConfig(config)
 	return config
 }
 
 func logConfig(cfg Configuration) {
-	log.Printf("Addressbook: %s", cfg.Addressbook)
+	for _, name := range cfg.BookNames() {
+		log.Printf("Addressbook %s: %s", name, cfg.Addressbooks[name])
+	}
+	log.Printf("DefaultBook: %s", cfg.DefaultBook)
 	log.Printf("Editor: %s", cfg.Editor)
+	log.Printf("Matching: %s", cfg.Matching)
+	log.Printf("PhoneRegion: %s", cfg.PhoneRegion)
+	log.Printf("Version: %s", cfg.Version)
+}
+
+// Names of the configured address books, sorted.
+func (c Configuration) BookNames() []string {
+	names := []string{}
+	for name := range c.Addressbooks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// The directory of the named address book.
+func (c Configuration) BookPath(name string) (string, error) {
+	if name == "" {
+		return "", errors.New("No default address book, set DefaultBook or use --book.")
+	}
+	path, ok := c.Addressbooks[name]
+	if !ok {
+		return "", fmt.Errorf("Unknown address book %q.", name)
+	}
+	return path, nil
 }
 
 func replaceHomeDir(path string) string {
@@ -0,0 +1,91 @@
+package contacts
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+
+	"github.com/xconstruct/vdir"
+)
+
+// the state of a card file at the time it was read
+type stamp struct {
+	Rev     string
+	ModTime time.Time
+	Hash    string
+}
+
+func newStamp(path string, card vdir.Card, data []byte) stamp {
+	var modTime time.Time
+	if info, err := os.Stat(path); err == nil {
+		modTime = info.ModTime()
+	}
+	sum := md5.Sum(data)
+	return stamp{card.Rev, modTime, hex.EncodeToString(sum[:])}
+}
+
+// A Conflict describes a card that was modified on disk
+// after it was loaded, e.g. by vdirsyncer or another instance.
+// If the file on disk cannot be read (e.g. it is only partly written),
+// Err is set and Theirs is nil.
+type Conflict struct {
+	Path    string
+	Theirs  *vdir.Card // nil if the card was deleted
+	ModTime time.Time
+	Err     error
+	current stamp
+}
+
+// Check if the file for the given card was modified since it was loaded.
+// Returns nil if the card is unchanged or was not loaded from this book.
+func (b *Addressbook) CheckModified(card vdir.Card) (*Conflict, error) {
+	path := b.cardPath(card)
+	loaded, ok := b.stamps[path]
+	if !ok {
+		return nil, nil
+	}
+
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return &Conflict{Path: path}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	// compare the raw file first, it may not be readable as a card
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	sum := md5.Sum(data)
+	if hex.EncodeToString(sum[:]) == loaded.Hash {
+		return nil, nil
+	}
+
+	theirs := new(vdir.Card)
+	err = unmarshalCard(data, theirs)
+	if err != nil {
+		log.Printf("%s was modified and cannot be read: %v", path, err)
+		current := stamp{"", info.ModTime(), hex.EncodeToString(sum[:])}
+		return &Conflict{path, nil, info.ModTime(), err, current}, nil
+	}
+	current := newStamp(path, *theirs, data)
+	log.Printf("%s was modified (REV %s, was %s)", path, current.Rev, loaded.Rev)
+	return &Conflict{path, theirs, current.ModTime, nil, current}, nil
+}
+
+// Accept the version on disk from the given conflict as the new base,
+// subsequent checks compare against that version.
+func (b *Addressbook) Rebase(conflict *Conflict) {
+	if conflict.Theirs == nil && conflict.Err == nil {
+		delete(b.stamps, conflict.Path)
+	} else {
+		if b.stamps == nil {
+			b.stamps = make(map[string]stamp)
+		}
+		b.stamps[conflict.Path] = conflict.current
+	}
+}
@@ -0,0 +1,52 @@
+package contacts
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/xconstruct/vdir"
+)
+
+func TestCheckModifiedUnreadable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "contacts-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// not created with NewAddressbook
+	book := &Addressbook{Dirname: dir}
+	card := vdir.Card{Uid: "1234", FormattedName: "John Doe"}
+	err = book.Save(card)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conflict, err := book.CheckModified(card)
+	if err != nil || conflict != nil {
+		t.Fatalf("Expected no conflict, got %v, %v", conflict, err)
+	}
+
+	// e.g. partly written by another program
+	path := filepath.Join(dir, "1234.vcf")
+	err = ioutil.WriteFile(path, []byte("BEGIN:VCARD\r\nVERSION:4.0\r\nFN:Jo"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conflict, err = book.CheckModified(card)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conflict == nil || conflict.Err == nil {
+		t.Fatalf("Expected a conflict with an error, got %+v", conflict)
+	}
+
+	// after a rebase, the same broken file is no longer a conflict
+	book.Rebase(conflict)
+	conflict, err = book.CheckModified(card)
+	if err != nil || conflict != nil {
+		t.Errorf("Expected no conflict after rebase, got %v, %v", conflict, err)
+	}
+}
@@ -0,0 +1,373 @@
+package contacts
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/xconstruct/vdir"
+)
+
+// A single content line of a vCard, e.g.
+//
+//	item1.EMAIL;TYPE=work,pref:jdoe@example.com
+//
+// Names are upper case, the value is kept as it appears in the file
+// (escaped, with folded lines joined).
+type property struct {
+	Group  string
+	Name   string
+	Params []param
+	Value  string
+	Line   int
+}
+
+type param struct {
+	Name   string
+	Values []string
+}
+
+// SyntaxError is returned for vCard data that cannot be parsed,
+// Line is the (one based) line number.
+type SyntaxError struct {
+	Line int
+	Msg  string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+// Parse vCard data into a list of properties.
+// Folded lines are joined, empty lines are skipped.
+func parseProperties(data []byte) ([]property, error) {
+	props := []property{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	var current string
+	start := 0
+	lineno := 0
+	flush := func() error {
+		if current == "" {
+			return nil
+		}
+		prop, err := parseProperty(current, start)
+		if err != nil {
+			return err
+		}
+		props = append(props, prop)
+		current = ""
+		return nil
+	}
+
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if softLineBreak(current) {
+			current = current[:len(current)-1] + line
+			continue
+		}
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			if current == "" {
+				return props, &SyntaxError{lineno, "continuation line without property"}
+			}
+			current += line[1:]
+			continue
+		}
+		if err := flush(); err != nil {
+			return props, err
+		}
+		if strings.TrimSpace(line) != "" {
+			current = line
+			start = lineno
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return props, &SyntaxError{lineno + 1, err.Error()}
+	}
+	return props, flush()
+}
+
+// A quoted-printable value (vCard 2.1) that ends with "="
+// continues on the next line.
+func softLineBreak(line string) bool {
+	if !strings.HasSuffix(line, "=") {
+		return false
+	}
+	colon := strings.Index(line, ":")
+	return colon >= 0 && strings.Contains(strings.ToUpper(line[:colon]), "QUOTED-PRINTABLE")
+}
+
+// parse a single (unfolded) content line
+func parseProperty(line string, lineno int) (property, error) {
+	prop := property{Line: lineno}
+	// name and parameters end at the first colon outside of quotes
+	quoted := false
+	colon := -1
+	for i, r := range line {
+		if r == '"' {
+			quoted = !quoted
+		} else if r == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return prop, &SyntaxError{lineno, fmt.Sprintf("missing ':' in %q", line)}
+	}
+	prop.Value = line[colon+1:]
+
+	parts := splitQuoted(line[:colon], ';')
+	name := parts[0]
+	if dot := strings.LastIndex(name, "."); dot >= 0 {
+		prop.Group = name[:dot]
+		name = name[dot+1:]
+	}
+	if !validName(name) {
+		return prop, &SyntaxError{lineno, fmt.Sprintf("invalid property name %q", name)}
+	}
+	prop.Name = strings.ToUpper(name)
+
+	for _, part := range parts[1:] {
+		eq := strings.Index(part, "=")
+		if eq < 0 {
+			// vCard 2.1 allows bare values for TYPE, e.g. "TEL;CELL:..."
+			prop.Params = append(prop.Params, param{"TYPE", []string{part}})
+			continue
+		}
+		name := strings.TrimSpace(part[:eq])
+		if !validName(name) {
+			return prop, &SyntaxError{lineno, fmt.Sprintf("invalid parameter name %q", name)}
+		}
+		values := []string{}
+		for _, v := range splitQuoted(part[eq+1:], ',') {
+			values = append(values, paramUnescaper.Replace(strings.Trim(v, "\"")))
+		}
+		prop.Params = append(prop.Params, param{strings.ToUpper(name), values})
+	}
+	return prop, nil
+}
+
+// split at `sep`, but not inside double quotes
+func splitQuoted(s string, sep rune) []string {
+	parts := []string{}
+	quoted := false
+	start := 0
+	for i, r := range s {
+		if r == '"' {
+			quoted = !quoted
+		} else if r == sep && !quoted {
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// names consist of letters, digits and dashes
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// Check that the properties form exactly one vCard
+// enclosed in BEGIN:VCARD and END:VCARD.
+func checkSingleCard(props []property) error {
+	if len(props) == 0 {
+		return &SyntaxError{1, "no vCard data"}
+	}
+	first := props[0]
+	if first.Name != "BEGIN" || !strings.EqualFold(first.Value, "VCARD") {
+		return &SyntaxError{first.Line, "expected BEGIN:VCARD"}
+	}
+
+	depth := 0
+	for i, prop := range props {
+		switch prop.Name {
+		case "BEGIN":
+			depth++
+		case "END":
+			depth--
+			if depth < 0 {
+				return &SyntaxError{prop.Line, "END without BEGIN"}
+			}
+			if depth == 0 && i != len(props)-1 {
+				return &SyntaxError{props[i+1].Line, "unexpected data after END:VCARD"}
+			}
+		}
+	}
+	if depth != 0 {
+		last := props[len(props)-1]
+		return &SyntaxError{last.Line, "missing END:VCARD"}
+	}
+	return nil
+}
+
+// Format a property as a content line (without folding).
+func (p property) String() string {
+	var buf bytes.Buffer
+	if p.Group != "" {
+		buf.WriteString(p.Group + ".")
+	}
+	buf.WriteString(p.Name)
+	for _, par := range p.Params {
+		// bare parameters (vCard 2.1) have no name
+		buf.WriteString(";")
+		if par.Name != "" {
+			buf.WriteString(par.Name + "=")
+		}
+		for i, v := range par.Values {
+			if i > 0 {
+				buf.WriteString(",")
+			}
+			v = paramEscaper.Replace(v)
+			if strings.ContainsAny(v, ":;,") {
+				v = "\"" + v + "\""
+			}
+			buf.WriteString(v)
+		}
+	}
+	buf.WriteString(":" + p.Value)
+	return buf.String()
+}
+
+// Parameter values cannot contain line breaks and double quotes,
+// these are written as ^n and ^' (RFC 6868), e.g. in a LABEL.
+var (
+	paramEscaper   = strings.NewReplacer("^", "^^", "\r\n", "^n", "\n", "^n", "\"", "^'")
+	paramUnescaper = strings.NewReplacer("^^", "^", "^n", "\n", "^N", "\n", "^'", "\"")
+)
+
+// Return the values of the parameter `name` or nil.
+func (p property) Param(name string) []string {
+	for _, par := range p.Params {
+		if par.Name == name {
+			return par.Values
+		}
+	}
+	return nil
+}
+
+// Write properties as content lines,
+// folded at 75 octets and terminated with CRLF.
+func writeProperties(w io.Writer, props []property) error {
+	for _, prop := range props {
+		line := prop.String()
+		// quoted-printable values have soft line breaks instead
+		if !prop.quotedPrintable() {
+			line = foldLine(line)
+		}
+		_, err := io.WriteString(w, line+"\r\n")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fold a line after 75 octets, but do not split UTF-8 sequences
+func foldLine(line string) string {
+	var buf bytes.Buffer
+	length := 0
+	for _, r := range line {
+		size := utf8.RuneLen(r)
+		if length+size > 75 {
+			buf.WriteString("\r\n ")
+			length = 1
+		}
+		buf.WriteRune(r)
+		length += size
+	}
+	return buf.String()
+}
+
+// Escape a text value.
+func escapeText(s string) string {
+	return textEscaper.Replace(s)
+}
+
+var textEscaper = strings.NewReplacer(
+	"\\", "\\\\", ",", "\\,", ";", "\\;", "\r\n", "\\n", "\n", "\\n")
+
+// Unescape a text value.
+func unescapeText(s string) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+			if s[i] == 'n' || s[i] == 'N' {
+				buf.WriteByte('\n')
+			} else {
+				buf.WriteByte(s[i])
+			}
+		} else {
+			buf.WriteByte(s[i])
+		}
+	}
+	return buf.String()
+}
+
+// Split an escaped value at unescaped separators,
+// the parts are still escaped.
+func splitEscaped(s string, sep byte) []string {
+	parts := []string{}
+	start := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' {
+			i++
+		} else if s[i] == sep {
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// Convert a card to properties, without BEGIN and END.
+// VERSION comes first.
+func cardProperties(card vdir.Card) ([]property, error) {
+	data, err := vdir.Marshal(card)
+	if err != nil {
+		return nil, err
+	}
+	props, err := parseProperties(data)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []property{{Name: "VERSION", Value: "4.0"}}
+	for _, prop := range props {
+		switch prop.Name {
+		case "BEGIN", "END":
+			continue
+		case "VERSION":
+			result[0].Value = prop.Value
+		default:
+			result = append(result, prop)
+		}
+	}
+	return result, nil
+}
+
+// Create a card from properties (without BEGIN and END).
+func propertiesCard(props []property) (vdir.Card, error) {
+	var card vdir.Card
+	var buf bytes.Buffer
+	err := writeCard(&buf, props)
+	if err != nil {
+		return card, err
+	}
+	err = unmarshalCard(buf.Bytes(), &card)
+	return card, err
+}
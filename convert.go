@@ -0,0 +1,438 @@
+package contacts
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"mime/quotedprintable"
+	"strings"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// Supported vCard versions for output
+var VCardVersions = []string{"2.1", "3.0", "4.0"}
+
+// Properties that exist in vCard 4.0 only, and their 3.0 extension names.
+var v4Properties = map[string]string{
+	"ANNIVERSARY": "X-ANNIVERSARY",
+	"GENDER":      "X-GENDER",
+	"KIND":        "X-ADDRESSBOOKSERVER-KIND",
+}
+
+// Convert properties (without BEGIN and END) to the given vCard version.
+// Encoded values (vCard 2.1 and 3.0) are decoded first.
+// Properties that cannot be expressed in the target version are renamed
+// to extension properties and restored when converting back.
+func convertProperties(props []property, version string) ([]property, error) {
+	props, err := decodeProperties(props)
+	if err != nil {
+		return nil, err
+	}
+	switch version {
+	case "2.1":
+		return toVCard21(toVCard3(props)), nil
+	case "3.0":
+		return toVCard3(props), nil
+	case "4.0":
+		return toVCard4(props), nil
+	}
+	return nil, fmt.Errorf("Unsupported vCard version %q", version)
+}
+
+func toVCard3(props []property) []property {
+	result := []property{}
+	hasN := false
+	for _, prop := range props {
+		prop.Params = append([]param{}, prop.Params...)
+		if name, ok := v4Properties[prop.Name]; ok {
+			prop.Name = name
+		}
+		switch prop.Name {
+		case "VERSION":
+			prop.Value = "3.0"
+		case "N":
+			hasN = true
+		case "PHOTO", "LOGO", "SOUND", "KEY":
+			prop = inline3(prop, "b")
+		case "TEL":
+			// TEL;VALUE=uri:tel:+1-555-0123
+			if strings.EqualFold(firstValue(prop.Param("VALUE")), "uri") {
+				prop.Params = withoutParam(prop.Params, "VALUE")
+				prop.Value = strings.TrimPrefix(prop.Value, "tel:")
+			}
+		case "BDAY", "X-ANNIVERSARY":
+			prop = date3(prop)
+		}
+		// PREF=1 becomes TYPE=pref
+		if pref := prop.Param("PREF"); pref != nil {
+			prop.Params = withoutParam(prop.Params, "PREF")
+			if firstValue(pref) == "1" {
+				prop.Params = addType(prop.Params, "pref")
+			}
+		}
+		result = append(result, prop)
+	}
+	// N is required in vCard 3.0
+	if !hasN {
+		result = append(result, property{Name: "N", Value: ";;;;"})
+	}
+	return result
+}
+
+func toVCard4(props []property) []property {
+	result := []property{}
+	for _, prop := range props {
+		prop.Params = append([]param{}, prop.Params...)
+		for name, extension := range v4Properties {
+			if prop.Name == extension {
+				prop.Name = name
+			}
+		}
+		switch prop.Name {
+		case "VERSION":
+			prop.Value = "4.0"
+		case "BDAY", "ANNIVERSARY":
+			prop = date4(prop)
+		}
+		// TYPE=pref becomes PREF=1
+		for i, par := range prop.Params {
+			if par.Name != "TYPE" {
+				continue
+			}
+			types := []string{}
+			for _, t := range par.Values {
+				if strings.EqualFold(t, "pref") {
+					prop.Params = append(prop.Params, param{"PREF", []string{"1"}})
+				} else {
+					types = append(types, t)
+				}
+			}
+			prop.Params[i].Values = types
+		}
+		prop.Params = withoutEmptyParams(prop.Params)
+		result = append(result, prop)
+	}
+	return result
+}
+
+// Convert properties from toVCard3 to vCard 2.1:
+// types are written as bare parameters, values with line breaks
+// or non-ASCII characters are quoted-printable.
+func toVCard21(props []property) []property {
+	result := []property{}
+	for _, prop := range props {
+		params := []param{}
+		for _, par := range prop.Params {
+			switch par.Name {
+			case "TYPE":
+				for _, t := range par.Values {
+					params = append(params, param{"", []string{strings.ToUpper(t)}})
+				}
+			case "ENCODING":
+				if strings.EqualFold(firstValue(par.Values), "b") {
+					par.Values = []string{"BASE64"}
+				}
+				params = append(params, par)
+			default:
+				params = append(params, par)
+			}
+		}
+		prop.Params = params
+
+		if prop.Name == "VERSION" {
+			prop.Value = "2.1"
+		} else if defaultValueType(prop.Name) == "text" && prop.Param("ENCODING") == nil {
+			prop = quotedPrintable(prop)
+		}
+		result = append(result, prop)
+	}
+	return result
+}
+
+// vCard 2.1 does not escape commas and newlines are encoded
+var text21Replacer = strings.NewReplacer("\\n", "\r\n", "\\N", "\r\n", "\\,", ",")
+
+func quotedPrintable(prop property) property {
+	value := text21Replacer.Replace(prop.Value)
+	plain := !strings.Contains(value, "\n")
+	for _, r := range value {
+		if r > 127 {
+			plain = false
+			break
+		}
+	}
+	if plain {
+		prop.Value = value
+		return prop
+	}
+
+	var buf bytes.Buffer
+	w := quotedprintable.NewWriter(&buf)
+	w.Binary = true // encode line breaks
+	w.Write([]byte(value))
+	w.Close()
+	prop.Params = append(prop.Params,
+		param{"ENCODING", []string{"QUOTED-PRINTABLE"}},
+		param{"CHARSET", []string{"UTF-8"}})
+	prop.Value = buf.String()
+	return prop
+}
+
+// Check if a property is quoted-printable (vCard 2.1)
+func (p property) quotedPrintable() bool {
+	if strings.EqualFold(firstValue(p.Param("ENCODING")), "QUOTED-PRINTABLE") {
+		return true
+	}
+	// parsed as "TYPE", see parseProperty
+	for _, par := range p.Params {
+		for _, v := range par.Values {
+			if par.Name == "TYPE" && strings.EqualFold(v, "QUOTED-PRINTABLE") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Check for values that need to be decoded, see decodeProperties
+func encodedProperties(props []property) bool {
+	for _, prop := range props {
+		if prop.Param("ENCODING") != nil || prop.Param("CHARSET") != nil || prop.quotedPrintable() {
+			return true
+		}
+		for _, t := range prop.Param("TYPE") {
+			if strings.EqualFold(t, "BASE64") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Decode quoted-printable, base64 and values in other charsets than UTF-8.
+// The result has no ENCODING or CHARSET parameters, text values are
+// escaped and binary values are data URIs.
+func decodeProperties(props []property) ([]property, error) {
+	result := []property{}
+	for _, prop := range props {
+		encoding := strings.ToUpper(firstValue(prop.Param("ENCODING")))
+		charset := firstValue(prop.Param("CHARSET"))
+		// vCard 2.1 has bare parameters, e.g. "TEL;WORK;VOICE",
+		// see parseProperty; they are joined into one TYPE
+		types := []string{}
+		params := []param{}
+		for _, par := range prop.Params {
+			switch par.Name {
+			case "ENCODING", "CHARSET":
+			case "TYPE":
+				if len(types) == 0 {
+					params = append(params, param{"TYPE", nil})
+				}
+				for _, t := range par.Values {
+					switch strings.ToUpper(t) {
+					case "QUOTED-PRINTABLE", "BASE64", "8BIT", "7BIT":
+						encoding = strings.ToUpper(t)
+					default:
+						types = append(types, t)
+					}
+				}
+			default:
+				params = append(params, par)
+			}
+		}
+		for i := range params {
+			if params[i].Name == "TYPE" {
+				params[i].Values = types
+			}
+		}
+		prop.Params = withoutEmptyParams(params)
+
+		value := prop.Value
+		switch encoding {
+		case "QUOTED-PRINTABLE":
+			data, err := ioutil.ReadAll(quotedprintable.NewReader(strings.NewReader(value)))
+			if err != nil {
+				return nil, &SyntaxError{prop.Line, fmt.Sprintf("invalid quoted-printable value: %v", err)}
+			}
+			value = string(data)
+		case "B", "BASE64":
+			result = append(result, dataURI(prop, value))
+			continue
+		}
+
+		if charset != "" && !strings.EqualFold(charset, "UTF-8") {
+			enc, err := htmlindex.Get(charset)
+			if err != nil {
+				return nil, &SyntaxError{prop.Line, fmt.Sprintf("unknown charset %q", charset)}
+			}
+			value, err = enc.NewDecoder().String(value)
+			if err != nil {
+				return nil, &SyntaxError{prop.Line, err.Error()}
+			}
+		}
+		if encoding == "QUOTED-PRINTABLE" {
+			value = strings.NewReplacer("\r\n", "\\n", "\n", "\\n").Replace(value)
+		}
+		prop.Value = value
+		result = append(result, prop)
+	}
+	return result, nil
+}
+
+// Media types for inline PHOTO, LOGO, SOUND and KEY,
+// vCard 2.1 and 3.0 give a TYPE like "JPEG".
+var mediaTypePrefix = map[string]string{
+	"PHOTO": "image/",
+	"LOGO":  "image/",
+	"SOUND": "audio/",
+	"KEY":   "application/",
+}
+
+// convert a base64 value to a data URI (vCard 4.0)
+func dataURI(prop property, value string) property {
+	mediatype := ""
+	if t := firstValue(prop.Param("TYPE")); t != "" {
+		mediatype = mediaTypePrefix[prop.Name] + strings.ToLower(t)
+		prop.Params = withoutParam(prop.Params, "TYPE")
+	}
+	prop.Params = withoutParam(prop.Params, "VALUE")
+	value = strings.Join(strings.Fields(value), "")
+	prop.Value = "data:" + mediatype + ";base64," + value
+	return prop
+}
+
+// convert a data URI to an inline value with the given encoding
+// ("b" for vCard 3.0, "BASE64" for 2.1), other URIs are kept.
+func inline3(prop property, encoding string) property {
+	if !strings.HasPrefix(prop.Value, "data:") {
+		if prop.Param("VALUE") == nil {
+			prop.Params = append(prop.Params, param{"VALUE", []string{"uri"}})
+		}
+		return prop
+	}
+	comma := strings.Index(prop.Value, ",")
+	if comma < 0 || !strings.HasSuffix(prop.Value[:comma], ";base64") {
+		return prop
+	}
+	header := prop.Value[len("data:"):comma]
+	data := prop.Value[comma+1:]
+	if _, err := base64.StdEncoding.DecodeString(data); err != nil {
+		return prop
+	}
+	prop.Params = withoutParam(prop.Params, "VALUE")
+	prop.Params = append(prop.Params, param{"ENCODING", []string{encoding}})
+	mediatype := strings.TrimSuffix(header, ";base64")
+	if i := strings.Index(mediatype, "/"); i >= 0 {
+		prop.Params = append(prop.Params, param{"TYPE", []string{strings.ToUpper(mediatype[i+1:])}})
+	}
+	prop.Value = data
+	return prop
+}
+
+// Year used by Apple for dates without a year, e.g.
+//
+//	BDAY;X-APPLE-OMIT-YEAR=1604:1604-04-15
+const omitYear = "1604"
+
+// vCard 3.0 has no dates without year, use the Apple convention
+func date3(prop property) property {
+	if !dateValue(prop) {
+		return prop
+	}
+	prop.Params = withoutParam(prop.Params, "VALUE")
+	value := extendedDateTime(prop.Value)
+	if groups := extMonthDay.FindStringSubmatch(value); groups != nil {
+		value = omitYear + "-" + groups[1] + "-" + groups[2]
+		prop.Params = append(prop.Params, param{"X-APPLE-OMIT-YEAR", []string{omitYear}})
+	}
+	prop.Value = value
+	return prop
+}
+
+func date4(prop property) property {
+	if !dateValue(prop) {
+		return prop
+	}
+	value := basicDateTime(prop.Value)
+	if year := firstValue(prop.Param("X-APPLE-OMIT-YEAR")); year != "" {
+		prop.Params = withoutParam(prop.Params, "X-APPLE-OMIT-YEAR")
+		if strings.HasPrefix(value, year) && len(value) == 8 {
+			value = "--" + value[4:]
+		}
+	}
+	prop.Value = value
+	return prop
+}
+
+// BDAY and ANNIVERSARY can also be text, e.g. "circa 1800"
+func dateValue(prop property) bool {
+	vtype := firstValue(prop.Param("VALUE"))
+	return vtype == "" || isDateType(strings.ToLower(vtype))
+}
+
+// Remove properties by name,
+// a name that ends with "*" removes all properties with that prefix.
+func stripProperties(props []property, names []string) []property {
+	result := []property{}
+	for _, prop := range props {
+		if !stripped(prop.Name, names) {
+			result = append(result, prop)
+		}
+	}
+	return result
+}
+
+// Whether a property is removed by stripProperties.
+func stripped(prop string, names []string) bool {
+	for _, name := range names {
+		name = strings.ToUpper(name)
+		if strings.HasSuffix(name, "*") {
+			if strings.HasPrefix(prop, name[:len(name)-1]) {
+				return true
+			}
+		} else if prop == name {
+			return true
+		}
+	}
+	return false
+}
+
+func firstValue(values []string) string {
+	if len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func withoutParam(params []param, name string) []param {
+	result := []param{}
+	for _, par := range params {
+		if par.Name != name {
+			result = append(result, par)
+		}
+	}
+	return result
+}
+
+func withoutEmptyParams(params []param) []param {
+	result := []param{}
+	for _, par := range params {
+		if len(par.Values) > 0 {
+			result = append(result, par)
+		}
+	}
+	return result
+}
+
+// add a value to the TYPE parameter
+func addType(params []param, value string) []param {
+	for i, par := range params {
+		if par.Name == "TYPE" {
+			params[i].Values = append(append([]string{}, par.Values...), value)
+			return params
+		}
+	}
+	return append(params, param{"TYPE", []string{value}})
+}
@@ -0,0 +1,184 @@
+package contacts
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/xconstruct/vdir"
+)
+
+// Default columns for CSV output
+var DefaultCSVColumns = []string{"name", "email", "tel", "org", "categories"}
+
+// Values for CSV columns.
+// Fields with several values give the first one (email, tel, url, adr)
+// or a comma separated list (nick, categories).
+var csvColumnValues = map[string]func(vdir.Card) string{
+	"name":  func(c vdir.Card) string { return strings.TrimSpace(FormatName(c)) },
+	"first": func(c vdir.Card) string { return strings.Join(c.Name.GivenName, " ") },
+	"last":  func(c vdir.Card) string { return strings.Join(c.Name.FamilyName, " ") },
+	"nick":  func(c vdir.Card) string { return strings.Join(c.NickName, ",") },
+	"email": PrimaryMail,
+	"tel":   PrimaryPhone,
+	"url": func(c vdir.Card) string {
+		if len(c.Url) > 0 {
+			return c.Url[0].Value
+		}
+		return ""
+	},
+	"adr": func(c vdir.Card) string {
+		if len(c.Addresses) > 0 {
+			return formatAddress(c.Addresses[0])
+		}
+		return ""
+	},
+	"org":         func(c vdir.Card) string { return c.Org },
+	"title":       func(c vdir.Card) string { return c.Title },
+	"role":        func(c vdir.Card) string { return c.Role },
+	"bday":        func(c vdir.Card) string { return c.Birthday },
+	"anniversary": func(c vdir.Card) string { return c.Anniversary },
+	"note":        func(c vdir.Card) string { return c.Note },
+	"categories":  func(c vdir.Card) string { return strings.Join(c.Categories, ",") },
+	"uid":         func(c vdir.Card) string { return c.Uid },
+}
+
+// Check that all columns can be written to CSV.
+// In addition to csvColumnValues, "book" is the name of the address book.
+func CheckCSVColumns(columns []string) error {
+	for _, column := range columns {
+		if _, ok := csvColumnValues[column]; !ok && column != "book" {
+			return fmt.Errorf("Unknown column %q", column)
+		}
+	}
+	return nil
+}
+
+// Write cards as CSV (RFC 4180) with a header row.
+//
+// With `expand`, each mail address and phone number gets a column of its
+// own, named after its type, e.g. "email.work", "email.work2", "tel.cell".
+// The names can be read back with the "generic" import profile.
+func writeCSV(writer io.Writer, matches []Match, columns []string, expand bool) error {
+	err := CheckCSVColumns(columns)
+	if err != nil {
+		return err
+	}
+	cards := []vdir.Card{}
+	for _, match := range matches {
+		cards = append(cards, match.Card)
+	}
+
+	header := []string{}
+	typed := map[string][]string{}
+	for _, column := range columns {
+		if expand && (column == "email" || column == "tel") {
+			typed[column] = typedColumns(cards, column)
+			header = append(header, typed[column]...)
+		} else {
+			header = append(header, column)
+		}
+	}
+
+	w := csv.NewWriter(writer)
+	w.UseCRLF = true
+	w.Write(header)
+	for _, match := range matches {
+		card := match.Card
+		record := []string{}
+		for _, column := range columns {
+			if column == "book" {
+				name := ""
+				if match.Book != nil {
+					name = match.Book.Name
+				}
+				record = append(record, name)
+				continue
+			}
+			names, ok := typed[column]
+			if !ok {
+				record = append(record, csvColumnValues[column](card))
+				continue
+			}
+			values := typedColumnValues(typedValuesOf(card, column), column)
+			for _, name := range names {
+				record = append(record, values[name])
+			}
+		}
+		w.Write(record)
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func typedValuesOf(card vdir.Card, column string) []vdir.TypedValue {
+	if column == "email" {
+		return card.Email
+	}
+	return card.Telephones
+}
+
+// column names for all typed values of the given kind,
+// in order of their first appearance.
+func typedColumns(cards []vdir.Card, column string) []string {
+	names := []string{}
+	seen := map[string]bool{}
+	for _, card := range cards {
+		for _, entry := range typedColumnEntries(typedValuesOf(card, column), column) {
+			if !seen[entry[0]] {
+				seen[entry[0]] = true
+				names = append(names, entry[0])
+			}
+		}
+	}
+	return names
+}
+
+// values of a single card by column name, e.g. "tel.cell" -> "+49 ..."
+func typedColumnValues(values []vdir.TypedValue, column string) map[string]string {
+	result := map[string]string{}
+	for _, entry := range typedColumnEntries(values, column) {
+		result[entry[0]] = entry[1]
+	}
+	return result
+}
+
+// pairs of column name and value,
+// a second value with the same type gets a number ("email.work2").
+func typedColumnEntries(values []vdir.TypedValue, column string) [][2]string {
+	entries := [][2]string{}
+	count := map[string]int{}
+	for _, tv := range values {
+		if tv.Value == "" {
+			continue
+		}
+		label := strings.ToLower(strings.Join(tv.Type, ","))
+		if label == "" {
+			label = "other"
+		}
+		name := column + "." + label
+		count[name]++
+		if n := count[name]; n > 1 {
+			name += strconv.Itoa(n)
+		}
+		entries = append(entries, [2]string{name, tv.Value})
+	}
+	return entries
+}
+
+// an address on a single line
+func formatAddress(adr vdir.Address) string {
+	parts := []string{}
+	for _, part := range []string{
+		adr.PostOfficeBox, adr.ExtendedAddress, adr.Street,
+		strings.TrimSpace(adr.PostalCode + " " + adr.Locality),
+		adr.Region, adr.CountryName} {
+		part = strings.TrimSpace(strings.Replace(part, "\n", ", ", -1))
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,463 @@
+package contacts
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xconstruct/vdir"
+)
+
+// CSV Import
+//
+// Each CSV column is mapped to a card field with a field spec:
+//
+//	KIND[.SLOT][:ATTR]
+//
+// Simple fields are given, family, additional, prefix, suffix, fn, nick,
+// org, title, role, bday, anniversary, note, categories and uid.
+// Fields with multiple values are email, tel, url and adr.
+// Columns with the same SLOT make up one value, e.g. "email.1" holds the
+// address and "email.1:type" its type. If there is no type column,
+// a non-numeric slot name is used as type ("tel.cell", "email.work").
+// Addresses have the attributes pobox, ext, street, city, region, code,
+// country and type ("adr.home:street").
+
+// a rule that maps matching column headers to a field spec,
+// the spec may refer to groups in the pattern ($1).
+type columnRule struct {
+	pattern *regexp.Regexp
+	spec    string
+}
+
+func columnRules(pairs ...string) []columnRule {
+	rules := []columnRule{}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		pattern := regexp.MustCompile("(?i)^" + pairs[i] + "$")
+		rules = append(rules, columnRule{pattern, pairs[i+1]})
+	}
+	return rules
+}
+
+// Built-in column mappings.
+var csvProfiles = map[string][]columnRule{
+	// Google Contacts, old and new export format
+	"google": columnRules(
+		"Name", "fn",
+		"(?:Given|First) Name", "given",
+		"(?:Additional|Middle) Name", "additional",
+		"(?:Family|Last) Name", "family",
+		"Name Prefix", "prefix",
+		"Name Suffix", "suffix",
+		"Nickname", "nick",
+		"Birthday", "bday",
+		"Notes", "note",
+		"(?:Group Membership|Labels)", "categories",
+		`Organization(?: 1 -)? Name`, "org",
+		`Organization(?: 1 -)? Title`, "title",
+		`E-mail (\d+) - (?:Type|Label)`, "email.$1:type",
+		`E-mail (\d+) - Value`, "email.$1",
+		`Phone (\d+) - (?:Type|Label)`, "tel.$1:type",
+		`Phone (\d+) - Value`, "tel.$1",
+		`Website (\d+) - (?:Type|Label)`, "url.$1:type",
+		`Website (\d+) - Value`, "url.$1",
+		`Address (\d+) - (?:Type|Label)`, "adr.$1:type",
+		`Address (\d+) - Street`, "adr.$1:street",
+		`Address (\d+) - City`, "adr.$1:city",
+		`Address (\d+) - PO Box`, "adr.$1:pobox",
+		`Address (\d+) - Region`, "adr.$1:region",
+		`Address (\d+) - Postal Code`, "adr.$1:code",
+		`Address (\d+) - Country`, "adr.$1:country",
+		`Address (\d+) - Extended Address`, "adr.$1:ext",
+	),
+	// Microsoft Outlook
+	"outlook": columnRules(
+		"Title", "prefix",
+		"First Name", "given",
+		"Middle Name", "additional",
+		"Last Name", "family",
+		"Suffix", "suffix",
+		"Company", "org",
+		"Job Title", "title",
+		"Birthday", "bday",
+		"Anniversary", "anniversary",
+		"Notes", "note",
+		"Categories", "categories",
+		"E-mail Address", "email.1",
+		"E-mail 2 Address", "email.2",
+		"E-mail 3 Address", "email.3",
+		"Business Phone", "tel.work",
+		"Business Phone 2", "tel.work2:value",
+		"Home Phone", "tel.home",
+		"Home Phone 2", "tel.home2:value",
+		"Mobile Phone", "tel.cell",
+		"Business Fax", "tel.work,fax",
+		"Home Fax", "tel.home,fax",
+		"Pager", "tel.pager",
+		"Other Phone", "tel.other",
+		"Web Page", "url.1",
+		"(Business|Home|Other) Street", "adr.$1:street",
+		"(Business|Home|Other) City", "adr.$1:city",
+		"(Business|Home|Other) State", "adr.$1:region",
+		"(Business|Home|Other) Postal Code", "adr.$1:code",
+		"(Business|Home|Other) Country(?:/Region)?", "adr.$1:country",
+		"(Business|Home|Other) PO Box", "adr.$1:pobox",
+	),
+	// column headers are field specs or `ls --format csv` columns
+	"generic": columnRules(
+		"name", "fn",
+		"first", "given",
+		"last", "family",
+		"(.*)", "$1",
+	),
+}
+
+// Read a user defined column mapping from a JSON file, e.g.
+//
+//	{"Vorname": "given", "Nachname": "family", "Handy": "tel.cell"}
+func ReadCSVMapping(path string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	mapping := map[string]string{}
+	err = json.Unmarshal(data, &mapping)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid mapping file %v: %v", path, err)
+	}
+	for column, spec := range mapping {
+		if _, err := parseFieldSpec(spec); err != nil {
+			return nil, fmt.Errorf("Invalid mapping for column %q: %v", column, err)
+		}
+	}
+	return mapping, nil
+}
+
+// a parsed field spec
+type fieldSpec struct {
+	kind string
+	slot string
+	attr string
+}
+
+var simpleFields = map[string]bool{
+	"given": true, "family": true, "additional": true, "prefix": true,
+	"suffix": true, "fn": true, "nick": true, "org": true, "title": true,
+	"role": true, "bday": true, "anniversary": true, "note": true,
+	"categories": true, "uid": true,
+}
+
+var addressAttrs = map[string]bool{
+	"pobox": true, "ext": true, "street": true, "city": true, "region": true,
+	"code": true, "country": true, "type": true,
+}
+
+func parseFieldSpec(s string) (fieldSpec, error) {
+	var spec fieldSpec
+	s = strings.TrimSpace(s)
+	if i := strings.Index(s, ":"); i >= 0 {
+		spec.attr = strings.ToLower(s[i+1:])
+		s = s[:i]
+	}
+	if i := strings.Index(s, "."); i >= 0 {
+		spec.slot = strings.ToLower(s[i+1:])
+		s = s[:i]
+	}
+	spec.kind = strings.ToLower(s)
+
+	switch spec.kind {
+	case "email", "tel", "url":
+		if spec.attr == "" {
+			spec.attr = "value"
+		}
+		if spec.attr != "value" && spec.attr != "type" {
+			return spec, fmt.Errorf("unknown attribute %q for %v", spec.attr, spec.kind)
+		}
+	case "adr":
+		if !addressAttrs[spec.attr] {
+			return spec, fmt.Errorf("unknown address attribute %q", spec.attr)
+		}
+	default:
+		if !simpleFields[spec.kind] {
+			return spec, fmt.Errorf("unknown field %q", spec.kind)
+		}
+	}
+	return spec, nil
+}
+
+// Map CSV headers to field specs.
+// Columns from the user defined `mapping` take precedence over the profile,
+// columns without a mapping are ignored (nil).
+func csvColumns(header []string, profile string, mapping map[string]string) ([]*fieldSpec, error) {
+	rules, ok := csvProfiles[profile]
+	if !ok && profile != "" {
+		return nil, fmt.Errorf("Unknown CSV profile %q", profile)
+	}
+
+	specs := make([]*fieldSpec, len(header))
+	for i, column := range header {
+		column = strings.TrimSpace(strings.TrimPrefix(column, "\ufeff"))
+		text, ok := mapping[column]
+		if !ok {
+			for _, rule := range rules {
+				if groups := rule.pattern.FindStringSubmatchIndex(column); groups != nil {
+					text = string(rule.pattern.ExpandString(nil, rule.spec, column, groups))
+					break
+				}
+			}
+		}
+		if text == "" {
+			log.Printf("Ignore CSV column %q", column)
+			continue
+		}
+		spec, err := parseFieldSpec(text)
+		if err != nil {
+			if profile == "generic" && !ok {
+				log.Printf("Ignore CSV column %q: %v", column, err)
+				continue
+			}
+			return nil, fmt.Errorf("Invalid mapping for column %q: %v", column, err)
+		}
+		specs[i] = &spec
+	}
+	return specs, nil
+}
+
+// Read CSV data with a header row, one card per row.
+// Rows without a name, organization, mail address or phone number are skipped.
+func readCSV(reader io.Reader, opts ImportOptions) ([]vdir.Card, []error, error) {
+	cards := []vdir.Card{}
+	failed := []error{}
+	r := csv.NewReader(reader)
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+
+	header, err := r.Read()
+	if err != nil {
+		return cards, failed, err
+	}
+	profile := opts.Profile
+	if profile == "" && opts.Mapping == nil {
+		profile = "generic"
+	}
+	specs, err := csvColumns(header, profile, opts.Mapping)
+	if err != nil {
+		return cards, failed, err
+	}
+
+	row := 1
+	for {
+		record, err := r.Read()
+		row++
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			failed = append(failed, fmt.Errorf("Row %v: %v", row, err))
+			continue
+		}
+		card := csvCard(specs, record)
+		if strings.TrimSpace(FormatName(card)) == "" && card.Org == "" &&
+			len(card.Email) == 0 && len(card.Telephones) == 0 {
+			log.Printf("Skip empty row %v", row)
+			continue
+		}
+		cards = append(cards, card)
+	}
+	return cards, failed, nil
+}
+
+// values of one slot, e.g. all columns for "email.1"
+type csvSlot struct {
+	types  []string
+	values []string
+	adr    vdir.Address
+}
+
+func csvCard(specs []*fieldSpec, record []string) vdir.Card {
+	var card vdir.Card
+	slots := map[string]*csvSlot{}
+	order := []string{}
+
+	for i, spec := range specs {
+		if spec == nil || i >= len(record) {
+			continue
+		}
+		value := strings.TrimSpace(record[i])
+		if value == "" {
+			continue
+		}
+		if simpleFields[spec.kind] {
+			setSimpleField(&card, spec.kind, value)
+			continue
+		}
+
+		key := spec.kind + "." + spec.slot
+		slot, ok := slots[key]
+		if !ok {
+			slot = &csvSlot{}
+			slots[key] = slot
+			order = append(order, key)
+		}
+		switch spec.attr {
+		case "type":
+			slot.types = csvTypes(value)
+		case "value":
+			// Google uses ":::" to separate multiple values
+			for _, v := range strings.Split(value, ":::") {
+				if v = strings.TrimSpace(v); v != "" {
+					slot.values = append(slot.values, v)
+				}
+			}
+		default:
+			setAddressField(&slot.adr, spec.attr, value)
+		}
+	}
+
+	for _, key := range order {
+		slot := slots[key]
+		parts := strings.SplitN(key, ".", 2)
+		kind, name := parts[0], parts[1]
+		types := slot.types
+		if types == nil {
+			types = csvTypes(name)
+		}
+		if kind == "adr" {
+			slot.adr.Type = types
+			card.Addresses = append(card.Addresses, slot.adr)
+			continue
+		}
+		for _, v := range slot.values {
+			tv := vdir.TypedValue{Type: types, Value: v}
+			switch kind {
+			case "email":
+				card.Email = append(card.Email, tv)
+			case "tel":
+				card.Telephones = append(card.Telephones, tv)
+			case "url":
+				card.Url = append(card.Url, tv)
+			}
+		}
+	}
+	return card
+}
+
+func setSimpleField(card *vdir.Card, kind, value string) {
+	switch kind {
+	case "given":
+		card.Name.GivenName = []string{value}
+	case "family":
+		card.Name.FamilyName = []string{value}
+	case "additional":
+		card.Name.AdditionalNames = []string{value}
+	case "prefix":
+		card.Name.HonorificNames = []string{value}
+	case "suffix":
+		card.Name.HonorificSuffixes = []string{value}
+	case "fn":
+		card.FormattedName = value
+	case "nick":
+		card.NickName = multiple(value)
+	case "org":
+		card.Org = value
+	case "title":
+		card.Title = value
+	case "role":
+		card.Role = value
+	case "bday":
+		card.Birthday = csvDate(value)
+	case "anniversary":
+		card.Anniversary = csvDate(value)
+	case "note":
+		card.Note = value
+	case "uid":
+		card.Uid = value
+	case "categories":
+		for _, c := range csvCategorySeparator.Split(value, -1) {
+			c = strings.TrimSpace(c)
+			if c != "" && !strings.HasPrefix(c, "*") {
+				card.Categories = append(card.Categories, c)
+			}
+		}
+	}
+}
+
+func setAddressField(adr *vdir.Address, attr, value string) {
+	switch attr {
+	case "pobox":
+		adr.PostOfficeBox = value
+	case "ext":
+		adr.ExtendedAddress = value
+	case "street":
+		if adr.Street != "" {
+			value = adr.Street + "\n" + value
+		}
+		adr.Street = value
+	case "city":
+		adr.Locality = value
+	case "region":
+		adr.Region = value
+	case "code":
+		adr.PostalCode = value
+	case "country":
+		adr.CountryName = value
+	}
+}
+
+// synonyms for types used in CSV exports
+var csvTypeNames = map[string]string{
+	"mobile":   "cell",
+	"business": "work",
+	"personal": "home",
+}
+
+// convert a type label like "* Work" or "Home Fax" to vCard types,
+// numbers (slot names) and "other" are dropped.
+func csvTypes(label string) []string {
+	types := []string{}
+	for _, t := range strings.FieldsFunc(strings.ToLower(label), func(r rune) bool {
+		return r == ' ' || r == ',' || r == '*'
+	}) {
+		if synonym, ok := csvTypeNames[t]; ok {
+			t = synonym
+		}
+		if _, err := strconv.Atoi(t); err == nil || t == "other" {
+			continue
+		}
+		// e.g. "work2" for a second work number
+		t = strings.TrimRight(t, "0123456789")
+		types = append(types, t)
+	}
+	return types
+}
+
+var (
+	// Google: "Friends ::: * myContacts", Outlook: "Friends;Work"
+	csvCategorySeparator = regexp.MustCompile(`:::|;|,`)
+	// Google, date without year
+	csvMonthDay = regexp.MustCompile(`^--(\d{2})-(\d{2})$`)
+)
+
+// convert a date as found in CSV exports to the vCard format.
+// Values that cannot be parsed are kept, "0/0/00" is empty (Outlook).
+func csvDate(value string) string {
+	if value == "0/0/00" {
+		return ""
+	}
+	// Google, date without year
+	if groups := csvMonthDay.FindStringSubmatch(value); groups != nil {
+		return "--" + groups[1] + groups[2]
+	}
+	for _, layout := range []string{"2006-01-02", "1/2/2006", "2.1.2006", "20060102"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.Format("20060102")
+		}
+	}
+	return value
+}
@@ -0,0 +1,188 @@
+package contacts
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xconstruct/vdir"
+)
+
+// A date from BDAY or ANNIVERSARY.
+// Parts that are not known are zero, e.g. the year in "--0415".
+type Date struct {
+	Year  int
+	Month time.Month
+	Day   int
+}
+
+// Date formats of vCard 4.0 (basic and reduced) and 3.0 (extended)
+var dateFormats = []*regexp.Regexp{
+	regexp.MustCompile(`^(?P<year>\d{4})-?(?P<month>\d{2})-?(?P<day>\d{2})$`),
+	regexp.MustCompile(`^--(?P<month>\d{2})-?(?P<day>\d{2})$`),
+	regexp.MustCompile(`^(?P<year>\d{4})-(?P<month>\d{2})$`),
+	regexp.MustCompile(`^(?P<year>\d{4})$`),
+	regexp.MustCompile(`^--(?P<month>\d{2})$`),
+	regexp.MustCompile(`^---(?P<day>\d{2})$`),
+}
+
+// Parse a date in one of the vCard formats:
+// 19850415, 1985-04-15 (vCard 3.0), --0415 or --04-15 (without year)
+// and partial dates like 1985-04, 1985, --04 or ---15.
+// A time after the date is ignored, text values (VALUE=text)
+// like "circa 1800" are an error.
+func ParseDate(value string) (Date, error) {
+	var date Date
+	s, _, _ := cutTime(strings.TrimSpace(value))
+	var groups []string
+	var format *regexp.Regexp
+	for _, format = range dateFormats {
+		if groups = format.FindStringSubmatch(s); groups != nil {
+			break
+		}
+	}
+	if groups == nil {
+		return date, fmt.Errorf("Not a date: %q", value)
+	}
+
+	for i, name := range format.SubexpNames() {
+		n, _ := strconv.Atoi(groups[i])
+		switch name {
+		case "year":
+			// the year for "no year" in vCard 3.0, see date3
+			if groups[i] != omitYear {
+				date.Year = n
+			}
+		case "month":
+			date.Month = time.Month(n)
+		case "day":
+			date.Day = n
+		}
+	}
+
+	if date.Month != 0 && (date.Month < 1 || date.Month > 12) {
+		return date, fmt.Errorf("Invalid date: %q", value)
+	}
+	if date.Day != 0 {
+		// Feb 29 is valid for unknown years
+		year, month := date.Year, date.Month
+		if year == 0 {
+			year = 2000
+		}
+		if month == 0 {
+			month = time.January
+		}
+		if date.Day > time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day() {
+			return date, fmt.Errorf("Invalid date: %q", value)
+		}
+	}
+	return date, nil
+}
+
+// Check if the date has month and day, so it happens once a year.
+func (d Date) Yearly() bool {
+	return d.Month != 0 && d.Day != 0
+}
+
+// Format as "15 Apr 1985", "15 Apr", "Apr 1985" or "1985"
+func (d Date) String() string {
+	parts := []string{}
+	if d.Day != 0 {
+		parts = append(parts, strconv.Itoa(d.Day))
+	}
+	if d.Month != 0 {
+		parts = append(parts, d.Month.String()[:3])
+	}
+	if d.Year != 0 {
+		parts = append(parts, strconv.Itoa(d.Year))
+	}
+	return strings.Join(parts, " ")
+}
+
+// The next time the date occurs, on or after the day of `now`.
+// Feb 29 is on Feb 28 in other years.
+func (d Date) Next(now time.Time) time.Time {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	for year := today.Year(); ; year++ {
+		day := d.Day
+		if d.Month == time.February && day == 29 && !isLeapYear(year) {
+			day = 28
+		}
+		next := time.Date(year, d.Month, day, 0, 0, 0, 0, now.Location())
+		if !next.Before(today) {
+			return next
+		}
+	}
+}
+
+func isLeapYear(year int) bool {
+	return year%4 == 0 && (year%100 != 0 || year%400 == 0)
+}
+
+// A birthday or anniversary
+type Occasion struct {
+	Card vdir.Card
+	Kind string // "birthday" or "anniversary"
+	Date Date
+	Next time.Time
+	// the age (or number of years) on the next occasion, zero if unknown
+	Years int
+	// the value if it is not a date (e.g. "circa 1800"), Next is zero then
+	Text string
+}
+
+// Check if the occasion has no date, only a text value.
+func (o Occasion) Undated() bool {
+	return o.Text != ""
+}
+
+// Find birthdays and anniversaries in the next `days` days,
+// counted from `now`, sorted by date.
+// Text values are included without a date, after the dated ones.
+// Dates without month or day are skipped.
+func Upcoming(cards []vdir.Card, days int, now time.Time) []Occasion {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	end := today.AddDate(0, 0, days)
+	found := []Occasion{}
+	for _, card := range cards {
+		for _, kind := range []string{"birthday", "anniversary"} {
+			value := card.Birthday
+			if kind == "anniversary" {
+				value = card.Anniversary
+			}
+			if value == "" {
+				continue
+			}
+			date, err := ParseDate(value)
+			if err != nil {
+				found = append(found, Occasion{Card: card, Kind: kind, Text: value})
+				continue
+			}
+			if !date.Yearly() {
+				continue
+			}
+			next := date.Next(now)
+			if next.After(end) {
+				continue
+			}
+			occasion := Occasion{card, kind, date, next, 0, ""}
+			if date.Year != 0 && date.Year <= next.Year() {
+				occasion.Years = next.Year() - date.Year
+			}
+			found = append(found, occasion)
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool {
+		if found[i].Undated() != found[j].Undated() {
+			return !found[i].Undated()
+		}
+		if found[i].Next.Equal(found[j].Next) {
+			return FormatName(found[i].Card) < FormatName(found[j].Card)
+		}
+		return found[i].Next.Before(found[j].Next)
+	})
+	return found
+}
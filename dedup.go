@@ -0,0 +1,404 @@
+package contacts
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/xconstruct/vdir"
+)
+
+// Cards are reported as duplicates if the confidence is at least this high.
+const dedupThreshold = 0.5
+
+// How much a single signal says about two cards being the same contact.
+// Several signals are combined, see combineScores.
+const (
+	scoreSameMail       = 0.9
+	scoreSamePhone      = 0.8
+	scoreSameName       = 0.6
+	scoreSimilarName    = 0.4 // not enough on its own
+	scoreSameOrgAndName = 0.8
+)
+
+// Given names that are used for each other,
+// e.g. "Bob Smith" and "Robert Smith" are likely the same person.
+var nicknameGroups = [][]string{
+	{"alexander", "alex", "sasha"},
+	{"andrew", "andy", "drew"},
+	{"anthony", "tony"},
+	{"benjamin", "ben", "benny"},
+	{"charles", "charlie", "chuck"},
+	{"christopher", "chris"},
+	{"christian", "chris"},
+	{"daniel", "dan", "danny"},
+	{"deborah", "deb", "debbie"},
+	{"edward", "ed", "eddie", "ted"},
+	{"elizabeth", "liz", "beth", "betty", "lisa", "elisabeth", "eliza"},
+	{"james", "jim", "jimmy"},
+	{"jennifer", "jen", "jenny"},
+	{"johannes", "hans", "johann", "jo"},
+	{"john", "jack", "johnny"},
+	{"joseph", "joe", "joey", "josef", "sepp"},
+	{"katherine", "kate", "katie", "kathy", "catherine", "katharina", "kathrin"},
+	{"margaret", "maggie", "meg", "peggy", "margarete", "grete"},
+	{"matthew", "matt"},
+	{"michael", "mike", "mick", "micha"},
+	{"nicholas", "nick", "nico", "nikolaus", "klaus"},
+	{"patricia", "pat", "patty", "trish"},
+	{"richard", "rick", "rich", "dick"},
+	{"robert", "bob", "bobby", "rob", "robbie", "bert"},
+	{"samuel", "sam"},
+	{"stephen", "steven", "steve", "stefan"},
+	{"susan", "sue", "susie", "susanne"},
+	{"thomas", "tom", "tommy"},
+	{"william", "will", "bill", "billy", "liam", "wilhelm", "willi"},
+	{"wolfgang", "wolf"},
+}
+
+// given name -> indexes into nicknameGroups
+var nicknameIndex = func() map[string][]int {
+	index := map[string][]int{}
+	for i, group := range nicknameGroups {
+		for _, name := range group {
+			index[name] = append(index[name], i)
+		}
+	}
+	return index
+}()
+
+// A group of cards that are likely the same contact.
+// Confidence is between 0 and 1,
+// Reasons tells why the cards are considered duplicates.
+type Duplicates struct {
+	Cards      []Match
+	Confidence float64
+	Reasons    []string
+}
+
+// what is compared between cards, normalized
+type dedupKeys struct {
+	mails  []string
+	phones []string // E.164
+	// all numbers with their national digits,
+	// for numbers that cannot be normalized
+	national []dedupPhone
+	name     string
+	given    string
+	family   string
+	org      string
+}
+
+type dedupPhone struct {
+	digits string // see nationalDigits
+	value  string
+	parsed bool
+}
+
+// Find groups of cards that are likely the same contact.
+// Signals are a shared mail address, the same phone number
+// (compared in E.164 format, numbers without country code are
+// taken to be from `region`; numbers that cannot be normalized are
+// compared by their digits) and the same or similar names,
+// e.g. "Bob Smith" and "Robert Smith"; similar names alone are not enough,
+// they also need the same organization or another signal.
+// Groups are sorted by confidence, highest first.
+func FindDuplicates(matches []Match, region string) []Duplicates {
+	keys := make([]dedupKeys, len(matches))
+	for i, match := range matches {
+		keys[i] = newDedupKeys(match.Card, region)
+	}
+
+	// pairs of cards with their signals
+	type pair struct{ a, b int }
+	reasons := map[pair][]string{}
+	scores := map[pair][]float64{}
+	add := func(a, b int, score float64, reason string) {
+		if a > b {
+			a, b = b, a
+		}
+		p := pair{a, b}
+		for _, r := range reasons[p] {
+			if r == reason {
+				return
+			}
+		}
+		reasons[p] = append(reasons[p], reason)
+		scores[p] = append(scores[p], score)
+	}
+
+	// cards that share a mail address or phone number
+	shared := func(cardValues func(k dedupKeys) []string, score float64, reason string) {
+		byValue := map[string][]int{}
+		for i, k := range keys {
+			for _, v := range cardValues(k) {
+				byValue[v] = append(byValue[v], i)
+			}
+		}
+		values := []string{}
+		for value := range byValue {
+			values = append(values, value)
+		}
+		sort.Strings(values)
+		for _, value := range values {
+			indexes := byValue[value]
+			for x := 0; x < len(indexes); x++ {
+				for y := x + 1; y < len(indexes); y++ {
+					if indexes[x] != indexes[y] {
+						add(indexes[x], indexes[y], score, fmt.Sprintf(reason, value))
+					}
+				}
+			}
+		}
+	}
+	shared(func(k dedupKeys) []string { return k.mails }, scoreSameMail, "same mail address %v")
+	shared(func(k dedupKeys) []string { return k.phones }, scoreSamePhone, "same phone number %v")
+
+	// numbers that cannot be normalized (e.g. "0170 1234567" without a region)
+	// are compared by their national digits with all other numbers
+	type phoneRef struct {
+		card  int
+		phone dedupPhone
+	}
+	byDigits := map[string][]phoneRef{}
+	for i, k := range keys {
+		for _, p := range k.national {
+			byDigits[p.digits] = append(byDigits[p.digits], phoneRef{i, p})
+		}
+	}
+	digitValues := []string{}
+	for d := range byDigits {
+		digitValues = append(digitValues, d)
+	}
+	sort.Strings(digitValues)
+	for _, d := range digitValues {
+		refs := byDigits[d]
+		for x := 0; x < len(refs); x++ {
+			for y := x + 1; y < len(refs); y++ {
+				a, b := refs[x], refs[y]
+				if a.card == b.card || (a.phone.parsed && b.phone.parsed) {
+					continue
+				}
+				value := a.phone.value
+				if a.phone.parsed {
+					value = b.phone.value
+				}
+				add(a.card, b.card, scoreSamePhone, fmt.Sprintf("same phone number %v", value))
+			}
+		}
+	}
+
+	// names are only compared for cards with the same family name,
+	// or the same name if there is no family name
+	byFamily := map[string][]int{}
+	for i, k := range keys {
+		if k.family != "" {
+			byFamily[k.family] = append(byFamily[k.family], i)
+		} else if k.name != "" {
+			byFamily["\x00"+k.name] = append(byFamily["\x00"+k.name], i)
+		}
+	}
+	for _, indexes := range byFamily {
+		for x := 0; x < len(indexes); x++ {
+			for y := x + 1; y < len(indexes); y++ {
+				a, b := keys[indexes[x]], keys[indexes[y]]
+				sameOrg := a.org != "" && a.org == b.org
+				if a.name == b.name {
+					score, reason := scoreSameName, "same name"
+					if sameOrg {
+						score, reason = scoreSameOrgAndName, "same name and organization"
+					}
+					add(indexes[x], indexes[y], score, reason)
+				} else if similarGivenNames(a.given, b.given) {
+					score, reason := scoreSimilarName, "similar names"
+					if sameOrg {
+						score, reason = scoreSameOrgAndName, "similar names, same organization"
+					}
+					add(indexes[x], indexes[y], score, reason)
+				}
+			}
+		}
+	}
+
+	// join pairs into groups, the strongest pairs first
+	pairs := []pair{}
+	pairScores := map[pair]float64{}
+	for p, s := range scores {
+		score := combineScores(s)
+		if score >= dedupThreshold {
+			pairs = append(pairs, p)
+			pairScores[p] = score
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		si, sj := pairScores[pairs[i]], pairScores[pairs[j]]
+		if si != sj {
+			return si > sj
+		}
+		if pairs[i].a != pairs[j].a {
+			return pairs[i].a < pairs[j].a
+		}
+		return pairs[i].b < pairs[j].b
+	})
+
+	parent := make([]int, len(matches))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	// a group is as certain as its weakest link
+	confidence := map[int]float64{}
+	groupReasons := map[int][]string{}
+	for _, p := range pairs {
+		ra, rb := find(p.a), find(p.b)
+		score := pairScores[p]
+		if ra != rb {
+			parent[rb] = ra
+			weakest := score
+			if c, ok := confidence[ra]; ok && c < weakest {
+				weakest = c
+			}
+			if c, ok := confidence[rb]; ok && c < weakest {
+				weakest = c
+			}
+			confidence[ra] = weakest
+			groupReasons[ra] = mergeStrings(groupReasons[ra], groupReasons[rb])
+			delete(confidence, rb)
+			delete(groupReasons, rb)
+		}
+		groupReasons[ra] = mergeStrings(groupReasons[ra], reasons[p])
+	}
+
+	groups := map[int]*Duplicates{}
+	roots := []int{}
+	for i, match := range matches {
+		root := find(i)
+		if _, ok := confidence[root]; !ok {
+			continue
+		}
+		group, ok := groups[root]
+		if !ok {
+			group = &Duplicates{Confidence: confidence[root], Reasons: groupReasons[root]}
+			groups[root] = group
+			roots = append(roots, root)
+		}
+		group.Cards = append(group.Cards, match)
+	}
+
+	found := []Duplicates{}
+	for _, root := range roots {
+		found = append(found, *groups[root])
+	}
+	sort.SliceStable(found, func(i, j int) bool {
+		return found[i].Confidence > found[j].Confidence
+	})
+	return found
+}
+
+func newDedupKeys(card vdir.Card, region string) dedupKeys {
+	var k dedupKeys
+	for _, mail := range card.Email {
+		value := strings.ToLower(strings.TrimSpace(strings.TrimPrefix(mail.Value, "mailto:")))
+		if value != "" {
+			k.mails = mergeStrings(k.mails, []string{value})
+		}
+	}
+	for _, tel := range card.Telephones {
+		number, err := ParsePhone(tel.Value, region)
+		if err == nil {
+			k.phones = mergeStrings(k.phones, []string{number.E164()})
+		}
+		// short numbers like "112" say nothing
+		if digits := nationalDigits(tel.Value); len(digits) >= 6 {
+			value := strings.TrimSpace(tel.Value)
+			k.national = append(k.national, dedupPhone{digits, value, err == nil})
+		}
+	}
+
+	k.name = strings.Join(strings.Fields(foldString(FormatName(card))), " ")
+	k.given = foldString(firstValue(card.Name.GivenName))
+	k.family = foldString(strings.Join(card.Name.FamilyName, " "))
+	if k.given == "" && k.family == "" {
+		// e.g. imported with a formatted name only
+		if words := strings.Fields(k.name); len(words) > 1 {
+			k.given = words[0]
+			k.family = words[len(words)-1]
+		}
+	}
+	k.given = strings.TrimSpace(k.given)
+	k.family = strings.TrimSpace(k.family)
+	k.org = strings.Join(strings.Fields(foldString(card.Org)), " ")
+	return k
+}
+
+// Check if two (folded) given names are likely the same,
+// e.g. "bob" and "robert" or "jonathan" and "johnathan".
+func similarGivenNames(a, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+	if a == b {
+		return true
+	}
+	for _, i := range nicknameIndex[a] {
+		for _, j := range nicknameIndex[b] {
+			if i == j {
+				return true
+			}
+		}
+	}
+	if len([]rune(a)) >= fuzzyMinLength && len([]rune(b)) >= fuzzyMinLength {
+		return similarity(a, b) >= 0.8
+	}
+	return false
+}
+
+// Combine independent signals:
+// the probability that at least one of them is right.
+func combineScores(scores []float64) float64 {
+	none := 1.0
+	for _, s := range scores {
+		none *= 1 - s
+	}
+	return 1 - none
+}
+
+// Write groups of duplicates as a JSON array, see docs/json.md.
+func WriteDuplicatesJSON(writer io.Writer, groups []Duplicates) error {
+	type jsonEntry struct {
+		Book string   `json:"book"`
+		Card JSONCard `json:"card"`
+	}
+	type jsonGroup struct {
+		Confidence float64     `json:"confidence"`
+		Reasons    []string    `json:"reasons"`
+		Contacts   []jsonEntry `json:"contacts"`
+	}
+	out := []jsonGroup{}
+	for _, group := range groups {
+		g := jsonGroup{
+			Confidence: float64(int(group.Confidence*100+0.5)) / 100,
+			Reasons:    group.Reasons,
+			Contacts:   []jsonEntry{},
+		}
+		for _, match := range group.Cards {
+			entry := jsonEntry{Card: jsonMatch(match)}
+			if match.Book != nil {
+				entry.Book = match.Book.Name
+			}
+			g.Contacts = append(g.Contacts, entry)
+		}
+		out = append(out, g)
+	}
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(out)
+}
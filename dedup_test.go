@@ -0,0 +1,94 @@
+package contacts
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/xconstruct/vdir"
+)
+
+func TestFindDuplicates(t *testing.T) {
+	card := func(name string, phones ...string) Match {
+		c := vdir.Card{FormattedName: name}
+		for _, phone := range phones {
+			c.Telephones = append(c.Telephones, vdir.TypedValue{Value: phone})
+		}
+		return Match{Card: c}
+	}
+	org := func(match Match, org string) Match {
+		match.Card.Org = org
+		return match
+	}
+	tests := []struct {
+		matches []Match
+		region  string
+		reasons []string // of the only group, nil for no duplicates
+	}{
+		{
+			[]Match{card("Anna Meier", "+49 170 1234567"), card("Meier Office", "+49 (0)170 123-4567")},
+			"",
+			[]string{"same phone number +491701234567"},
+		},
+		// cannot be normalized without a region
+		{
+			[]Match{card("Anna Meier", "+49 170 1234567"), card("Meier Office", "0170 1234567")},
+			"",
+			[]string{"same phone number 0170 1234567"},
+		},
+		{
+			[]Match{card("Anna Meier", "0170 1234567"), card("Meier Office", "0170/123 45 67")},
+			"",
+			[]string{"same phone number 0170 1234567"},
+		},
+		{
+			[]Match{card("Anna Meier", "+49 170 1234567"), card("Meier Office", "+43 170 1234567")},
+			"",
+			nil,
+		},
+		{
+			[]Match{card("Anna Meier", "112"), card("Meier Office", "112")},
+			"",
+			nil,
+		},
+		// similar names only in the same organization
+		{
+			[]Match{card("Bob Smith"), card("Robert Smith")},
+			"",
+			nil,
+		},
+		{
+			[]Match{org(card("Bob Smith"), "ACME"), org(card("Robert Smith"), "ACME")},
+			"",
+			[]string{"similar names, same organization"},
+		},
+		{
+			[]Match{card("Bob Smith", "+49 170 1234567"), card("Robert Smith", "0170 1234567")},
+			"",
+			[]string{"same phone number 0170 1234567", "similar names"},
+		},
+		{
+			[]Match{card("Bob Smith"), card("Alice Smith")},
+			"",
+			nil,
+		},
+	}
+	for _, test := range tests {
+		groups := FindDuplicates(test.matches, test.region)
+		if test.reasons == nil {
+			if len(groups) != 0 {
+				t.Errorf("%v: got %v, want no duplicates", FormatName(test.matches[1].Card), groups[0].Reasons)
+			}
+			continue
+		}
+		if len(groups) != 1 {
+			t.Errorf("%v: got %d groups, want 1", FormatName(test.matches[1].Card), len(groups))
+			continue
+		}
+		if !reflect.DeepEqual(groups[0].Reasons, test.reasons) {
+			t.Errorf("%v: got %v, want %v", FormatName(test.matches[1].Card), groups[0].Reasons, test.reasons)
+		}
+		if groups[0].Confidence < dedupThreshold {
+			t.Errorf("%v: confidence %v is below the threshold", FormatName(test.matches[1].Card), groups[0].Confidence)
+		}
+	}
+}
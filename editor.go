@@ -2,6 +2,7 @@ package contacts
 
 import (
 	"bufio"
+	"bytes"
 	"crypto/md5"
 	"encoding/hex"
 	"errors"
@@ -19,6 +20,36 @@ import (
 // When the editor exits, apply changes to the card;
 // return `true` if the card was modified.
 func EditCard(cfg Configuration, card *vdir.Card) (bool, error) {
+	return editCard(cfg, card, nil)
+}
+
+// Like EditCard, but show the conflicting version `theirs`
+// as a comment above the card that is edited.
+// `theirs` is nil if the card was deleted.
+func EditConflict(cfg Configuration, card, theirs *vdir.Card) (bool, error) {
+	var header bytes.Buffer
+	if theirs == nil {
+		header.WriteString("# This contact was deleted by another program.\n")
+	} else {
+		header.WriteString("# This contact was changed by another program.\n")
+		header.WriteString("# The version on disk is:\n#\n")
+		var rendered bytes.Buffer
+		err := FillTemplate(&rendered, "edit.tpl", theirs)
+		if err != nil {
+			return false, err
+		}
+		// not "# ", parseTemplate would take the section headers
+		// of their version for the ones of the card that is edited
+		scanner := bufio.NewScanner(&rendered)
+		for scanner.Scan() {
+			header.WriteString("#| " + scanner.Text() + "\n")
+		}
+	}
+	header.WriteString("#\n# Edit your version below, it will replace the one on disk.\n\n")
+	return editCard(cfg, card, header.Bytes())
+}
+
+func editCard(cfg Configuration, card *vdir.Card, header []byte) (bool, error) {
 	modified := false
 	tempfile, err := ioutil.TempFile("", "edit-card-")
 	if err != nil {
@@ -26,6 +57,10 @@ func EditCard(cfg Configuration, card *vdir.Card) (bool, error) {
 	}
 	defer os.Remove(tempfile.Name())
 
+	_, err = tempfile.Write(header)
+	if err != nil {
+		return modified, err
+	}
 	err = FillTemplate(tempfile, "edit.tpl", card)
 	if err != nil {
 		return modified, err
@@ -0,0 +1,79 @@
+package contacts
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/xconstruct/vdir"
+)
+
+// an "editor" that changes the organization of the card that is edited
+func orgEditor(t *testing.T, org string) string {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell script as editor")
+	}
+	script := filepath.Join(t.TempDir(), "editor")
+	content := "#!/bin/sh\n" +
+		"sed 's/^Organization *:.*/Organization : " + org + "/' \"$1\" > \"$1.new\" && mv \"$1.new\" \"$1\"\n"
+	err := ioutil.WriteFile(script, []byte(content), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return script
+}
+
+func TestEditConflict(t *testing.T) {
+	cfg := Configuration{Editor: orgEditor(t, "New Org")}
+	card := &vdir.Card{
+		FormattedName: "John Doe",
+		Org:           "Mine",
+		Title:         "Boss",
+		Email:         []vdir.TypedValue{{Type: []string{"work"}, Value: "john@example.com"}},
+		Note:          "my note",
+	}
+	theirs := &vdir.Card{
+		FormattedName: "John Doe",
+		Org:           "Theirs",
+		Email:         []vdir.TypedValue{{Type: []string{"home"}, Value: "john@example.org"}},
+		Telephones:    []vdir.TypedValue{{Type: []string{"cell"}, Value: "+49 170 1234567"}},
+		Url:           []vdir.TypedValue{{Type: []string{"home"}, Value: "http://example.org"}},
+		Note:          "their note",
+	}
+
+	modified, err := EditConflict(cfg, card, theirs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !modified {
+		t.Fatal("card not modified")
+	}
+	if card.Org != "New Org" {
+		t.Errorf("Org is %q, want %q", card.Org, "New Org")
+	}
+	if card.Title != "Boss" {
+		t.Errorf("Title is %q, want %q", card.Title, "Boss")
+	}
+	if len(card.Email) != 1 || card.Email[0].Value != "john@example.com" {
+		t.Errorf("Email is %v, want only john@example.com", card.Email)
+	}
+	if len(card.Telephones) != 0 {
+		t.Errorf("Telephones is %v, want none", card.Telephones)
+	}
+	if card.Note != "my note" {
+		t.Errorf("Note is %q, want %q", card.Note, "my note")
+	}
+}
+
+func TestEditConflictDeleted(t *testing.T) {
+	cfg := Configuration{Editor: orgEditor(t, "New Org")}
+	card := &vdir.Card{FormattedName: "John Doe", Org: "Mine"}
+	_, err := EditConflict(cfg, card, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if card.Org != "New Org" {
+		t.Errorf("Org is %q, want %q", card.Org, "New Org")
+	}
+}
@@ -0,0 +1,87 @@
+package contacts
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/xconstruct/vdir"
+)
+
+// Options for reading cards.
+// Profile and Mapping select the columns for CSV, see csvimport.go.
+type ImportOptions struct {
+	Profile string
+	Mapping map[string]string
+}
+
+// A card read by ReadCards, see Addressbook.Import.
+// For vCard, jCard and xCard, the properties are kept as they were read,
+// including parameters and properties that vdir does not know.
+type ImportedCard struct {
+	vdir.Card
+	props []property
+}
+
+// Read cards in the given format.
+// Cards that cannot be read are reported in the list of errors,
+// the error return is set if the input as a whole cannot be read.
+func ReadCards(reader io.Reader, format string, opts ImportOptions) ([]ImportedCard, []error, error) {
+	switch format {
+	case "vcf":
+		return readVCF(reader)
+	case "jcard":
+		return readJCard(reader)
+	case "xcard":
+		return readXCard(reader)
+	case "csv":
+		return imported(readCSV(reader, opts))
+	case "ldif":
+		return imported(readLDIF(reader))
+	case "abook":
+		return imported(readAbook(reader))
+	}
+	return nil, nil, fmt.Errorf("Unknown import format %q", format)
+}
+
+// cards that were read without their properties
+func imported(cards []vdir.Card, failed []error, err error) ([]ImportedCard, []error, error) {
+	result := []ImportedCard{}
+	for _, card := range cards {
+		result = append(result, ImportedCard{Card: card})
+	}
+	return result, failed, err
+}
+
+// Options for writing cards.
+// Strip lists properties to leave out (see stripProperties),
+// Version is the vCard version for "vcf", default is 4.0.
+type ExportOptions struct {
+	Strip   []string
+	Version string
+}
+
+// Private properties, removed with `export --no-private`
+var PrivateProperties = []string{"NOTE", "BDAY", "X-*"}
+
+// Write cards in the given format.
+// vCard, jCard and xCard are written from the files of the cards,
+// see exportProperties.
+func WriteCards(writer io.Writer, matches []Match, format string, opts ExportOptions) error {
+	cards := []vdir.Card{}
+	for _, match := range matches {
+		cards = append(cards, match.Card)
+	}
+	switch format {
+	case "vcf":
+		return writeVCF(writer, matches, opts)
+	case "jcard":
+		return writeJCard(writer, matches, opts)
+	case "xcard":
+		return writeXCard(writer, matches, opts)
+	case "ldif":
+		return writeLDIF(writer, cards, opts)
+	case "abook":
+		return writeAbook(writer, cards, opts)
+	}
+	return fmt.Errorf("Unknown export format %q", format)
+}
@@ -0,0 +1,76 @@
+package contacts
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xconstruct/vdir"
+)
+
+func TestExportStrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "contacts-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	vcard := "BEGIN:VCARD\r\nVERSION:4.0\r\nUID:1234\r\nFN:John Doe\r\n" +
+		"EMAIL:john@example.com\r\nTEL:+49 170 1234567\r\nNOTE:my note\r\n" +
+		"BDAY:19850412\r\nANNIVERSARY:20090808\r\nGENDER:M\r\nX-FOO:bar\r\nEND:VCARD\r\n"
+	err = ioutil.WriteFile(filepath.Join(dir, "1234.vcf"), []byte(vcard), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	matches, err := NewAddressbook(dir).Rank(Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		format  string
+		opts    ExportOptions
+		want    []string
+		notWant []string
+	}{
+		{"vcf", ExportOptions{Version: "3.0"},
+			[]string{"X-ANNIVERSARY", "X-GENDER", "X-FOO", "NOTE"}, nil},
+		// X-* also leaves out what vCard 3.0 has only as X- property
+		{"vcf", ExportOptions{Version: "3.0", Strip: PrivateProperties},
+			[]string{"FN:John Doe", "EMAIL"}, []string{"ANNIVERSARY", "GENDER", "X-FOO", "NOTE", "BDAY"}},
+		{"vcf", ExportOptions{Version: "3.0", Strip: []string{"gender"}},
+			[]string{"X-ANNIVERSARY"}, []string{"GENDER"}},
+		{"vcf", ExportOptions{Strip: PrivateProperties},
+			[]string{"ANNIVERSARY", "GENDER"}, []string{"X-FOO", "NOTE", "BDAY"}},
+		{"ldif", ExportOptions{Strip: []string{"EMAIL", "TEL"}},
+			[]string{"dn: cn=John Doe\n", "description: my note"}, []string{"mail", "telephoneNumber", "mobile"}},
+		{"abook", ExportOptions{Strip: []string{"EMAIL", "TEL", "FN"}},
+			[]string{"notes=my note"}, []string{"name=", "email=", "phone="}},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		err = WriteCards(&buf, matches, test.format, test.opts)
+		if err != nil {
+			t.Errorf("%v %v: %v", test.format, test.opts, err)
+			continue
+		}
+		for _, s := range test.want {
+			if !strings.Contains(buf.String(), s) {
+				t.Errorf("%v %v: %q is missing in\n%s", test.format, test.opts, s, buf.String())
+			}
+		}
+		for _, s := range test.notWant {
+			if strings.Contains(buf.String(), s) {
+				t.Errorf("%v %v: %q is not stripped from\n%s", test.format, test.opts, s, buf.String())
+			}
+		}
+	}
+
+	cards := []Match{{Card: vdir.Card{FormattedName: "John Doe"}}}
+	err = WriteCards(&bytes.Buffer{}, cards, "ldif", ExportOptions{Strip: []string{"FN"}})
+	if err == nil {
+		t.Error("Expected an error for FN in LDIF")
+	}
+}
@@ -0,0 +1,37 @@
+package contacts
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Letters that do not decompose into a base letter and a diacritic
+// but are commonly written without it.
+var foldReplacer = strings.NewReplacer(
+	"ß", "ss", "ẞ", "ss",
+	"æ", "ae", "Æ", "ae",
+	"œ", "oe", "Œ", "oe",
+	"ø", "o", "Ø", "o",
+	"đ", "d", "Đ", "d",
+	"ð", "d", "Ð", "d",
+	"ł", "l", "Ł", "l",
+	"þ", "th", "Þ", "th",
+	"ı", "i",
+)
+
+// Fold a string for accent insensitive comparison:
+// decompose, strip diacritics, replace special letters (e.g. ß -> ss)
+// and convert to lower case. "Müller", "MULLER" and "Müller"
+// all fold to "muller".
+func foldString(s string) string {
+	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	folded, _, err := transform.String(t, s)
+	if err != nil {
+		folded = s
+	}
+	return strings.ToLower(foldReplacer.Replace(folded))
+}
@@ -0,0 +1,68 @@
+package contacts
+
+import "testing"
+
+func TestFoldString(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		// German
+		{"Straße", "strasse"},
+		{"STRAẞE", "strasse"},
+		{"Müller", "muller"},
+		{"Müller", "muller"},
+		// French
+		{"Hélène", "helene"},
+		{"François", "francois"},
+		{"Œuvre", "oeuvre"},
+		// Scandinavian
+		{"Ångström", "angstrom"},
+		{"Søren", "soren"},
+		{"Ærø", "aero"},
+		// Turkish, dotted and dotless I
+		{"İstanbul", "istanbul"},
+		{"Işık", "isik"},
+		{"IŞIK", "isik"},
+	}
+	for _, test := range tests {
+		if got := foldString(test.in); got != test.want {
+			t.Errorf("foldString(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestQueryEqualFoldAndStrict(t *testing.T) {
+	tests := []struct {
+		a, b   string
+		fold   bool
+		strict bool
+	}{
+		{"Straße", "Strasse", true, false},
+		{"Straße", "STRASSE", true, false},
+		{"MÜLLER", "müller", true, true},
+		{"Müller", "Muller", true, false},
+		{"Müller", "Müller", true, false},
+		{"Hélène", "Helene", true, false},
+		{"HÉLÈNE", "hélène", true, true},
+		{"Ångström", "Angstrom", true, false},
+		{"Søren", "Soren", true, false},
+		{"Æsa", "Aesa", true, false},
+		// Go lowercases İ to i, but I stays i and not ı
+		{"İstanbul", "istanbul", true, true},
+		{"ISTANBUL", "ıstanbul", true, false},
+		{"Işık", "Isik", true, false},
+		{"ışık", "IŞIK", true, false},
+		{"Müller", "Miller", false, false},
+	}
+	fold := Query{}
+	strict := Query{Strict: true}
+	for _, test := range tests {
+		if got := fold.equal(test.a, test.b); got != test.fold {
+			t.Errorf("fold: equal(%q, %q) = %v, want %v", test.a, test.b, got, test.fold)
+		}
+		if got := strict.equal(test.a, test.b); got != test.strict {
+			t.Errorf("strict: equal(%q, %q) = %v, want %v", test.a, test.b, got, test.strict)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package contacts
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/xconstruct/vdir"
+)
+
+// Cards that do not contain the search term are still found
+// if their score is at least this high (e.g. "jhon" finds "John").
+const fuzzyThreshold = 0.5
+
+// Terms shorter than this are not matched fuzzily.
+const fuzzyMinLength = 4
+
+// A card found by a query, with a score between 0 and 1
+// that tells how well it matches.
+type Match struct {
+	Card  vdir.Card
+	Score float64
+	// the address book that contains the card
+	Book *Addressbook
+	// the card does not match the query, but is similar
+	Fuzzy bool
+}
+
+// Only the matches that are not fuzzy.
+// Use this for anything that changes cards or works on many cards.
+func ExactMatches(matches []Match) []Match {
+	exact := []Match{}
+	for _, match := range matches {
+		if !match.Fuzzy {
+			exact = append(exact, match)
+		}
+	}
+	return exact
+}
+
+// Check if the best match is clearly better than the second best.
+// For a single match, this is true unless it is fuzzy:
+// a card that does not contain the search term is never picked without asking.
+func Dominant(matches []Match) bool {
+	if len(matches) == 0 || matches[0].Fuzzy {
+		return false
+	} else if len(matches) == 1 {
+		return true
+	}
+	return matches[0].Score-matches[1].Score >= 0.25
+}
+
+// Score how well the card matches the plain search terms in the query.
+// Exact matches of a name, nick name, mail address or phone number
+// score 1, prefixes and substrings score less
+// and similar words (with typos) score at most 0.7.
+// Queries without plain terms score 1 for every card.
+func (q Query) Score(card vdir.Card) float64 {
+	words := strings.Fields(q.normalize(q.fuzzyTerm()))
+	if len(words) == 0 {
+		return 1
+	}
+	candidates := []string{}
+	for _, s := range q.fuzzyCandidates(card) {
+		candidates = append(candidates, q.normalize(s))
+	}
+
+	whole := strings.Join(words, " ")
+	total := 0.0
+	for _, word := range words {
+		best := 0.0
+		for _, candidate := range candidates {
+			if candidate == whole {
+				return 1
+			}
+			if score := wordScore(word, candidate); score > best {
+				best = score
+			}
+		}
+		if isDigits(word) && len(word) >= 3 {
+			for _, tel := range card.Telephones {
+				if strings.Contains(digits(tel.Value), word) && best < 0.8 {
+					best = 0.8
+				}
+			}
+		}
+		total += best
+	}
+	return total / float64(len(words))
+}
+
+// Fuzzy matching is used for queries that consist of plain terms only,
+// return these terms or an empty string.
+func (q Query) fuzzyTerm() string {
+	if q.expr == nil {
+		return q.Term
+	}
+	terms := []string{}
+	var collect func(e expr) bool
+	collect = func(e expr) bool {
+		switch x := e.(type) {
+		case termExpr:
+			terms = append(terms, x.term)
+			return true
+		case andExpr:
+			return collect(x.left) && collect(x.right)
+		}
+		return false
+	}
+	if !collect(q.expr) {
+		return ""
+	}
+	return strings.Join(terms, " ")
+}
+
+// the values that are compared with a search term
+func (q Query) fuzzyCandidates(card vdir.Card) []string {
+	candidates := []string{card.FormattedName}
+	candidates = append(candidates, card.Name.GivenName...)
+	candidates = append(candidates, card.Name.FamilyName...)
+	candidates = append(candidates, card.NickName...)
+	candidates = append(candidates, typedValues(card.Email)...)
+	return candidates
+}
+
+// score a single (normalized) search word against a candidate value
+func wordScore(word, candidate string) float64 {
+	if word == candidate {
+		return 1
+	}
+	best := 0.0
+	if strings.Contains(candidate, word) {
+		best = 0.8
+	}
+	tokens := strings.FieldsFunc(candidate, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, token := range tokens {
+		if token == word {
+			return 1
+		} else if strings.HasPrefix(token, word) && best < 0.9 {
+			best = 0.9
+		} else if len([]rune(word)) >= fuzzyMinLength {
+			if score := 0.7 * similarity(word, token); score > best {
+				best = score
+			}
+		}
+	}
+	return best
+}
+
+// similarity of two strings between 0 (nothing in common) and 1 (equal),
+// based on the edit distance.
+func similarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	longest := len(ra)
+	if len(rb) > longest {
+		longest = len(rb)
+	}
+	if longest == 0 {
+		return 1
+	}
+	return 1 - float64(editDistance(ra, rb))/float64(longest)
+}
+
+// Damerau-Levenshtein distance (optimal string alignment),
+// a swap of two adjacent letters counts as a single edit.
+func editDistance(a, b []rune) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = min3(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				if d[i-2][j-2]+1 < d[i][j] {
+					d[i][j] = d[i-2][j-2] + 1
+				}
+			}
+		}
+	}
+	return d[len(a)][len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// only the digits from s
+func digits(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, s)
+}
+
+// Sort Helper, best matches first
+type byScore []Match
+
+func (a byScore) Len() int {
+	return len(a)
+}
+
+func (a byScore) Swap(front, back int) {
+	a[front], a[back] = a[back], a[front]
+}
+
+func (a byScore) Less(i, j int) bool {
+	return a[i].Score > a[j].Score
+}
@@ -0,0 +1,79 @@
+package contacts
+
+import (
+	"testing"
+
+	"github.com/xconstruct/vdir"
+)
+
+func TestRankThreshold(t *testing.T) {
+	book := &Addressbook{cards: []vdir.Card{
+		{FormattedName: "John Doe", Name: vdir.Name{GivenName: []string{"John"}, FamilyName: []string{"Doe"}}},
+		{FormattedName: "Jane Smith", Name: vdir.Name{GivenName: []string{"Jane"}, FamilyName: []string{"Smith"}}},
+		{FormattedName: "Maria Miller", Name: vdir.Name{GivenName: []string{"Maria"}, FamilyName: []string{"Miller"}}},
+	}}
+	tests := []struct {
+		term  string
+		name  string // the best match, empty for none
+		fuzzy bool
+	}{
+		{"john", "John Doe", false},
+		{"smi", "Jane Smith", false},
+		// near misses: one typo or swapped letters
+		{"jhon", "John Doe", true},
+		{"jonh", "John Doe", true},
+		{"smiht", "Jane Smith", true},
+		{"millre", "Maria Miller", true},
+		// far misses
+		{"jxxn", "", false},
+		{"xavier", "", false},
+		{"johnathan", "", false},
+		// too short to be matched fuzzily
+		{"jon", "", false},
+	}
+	for _, test := range tests {
+		query, err := ParseQuery(test.term, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		matches, err := book.Rank(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.name == "" {
+			if len(matches) > 0 {
+				t.Errorf("%q: expected no match, got %v (%.2f)", test.term,
+					matches[0].Card.FormattedName, matches[0].Score)
+			}
+			continue
+		}
+		if len(matches) == 0 {
+			t.Errorf("%q: expected %v, got no match", test.term, test.name)
+			continue
+		}
+		best := matches[0]
+		if best.Card.FormattedName != test.name || best.Fuzzy != test.fuzzy {
+			t.Errorf("%q: expected %v (fuzzy %v), got %v (fuzzy %v, %.2f)", test.term,
+				test.name, test.fuzzy, best.Card.FormattedName, best.Fuzzy, best.Score)
+		}
+	}
+}
+
+func TestDominant(t *testing.T) {
+	tests := []struct {
+		matches  []Match
+		dominant bool
+	}{
+		{[]Match{}, false},
+		{[]Match{{Score: 1}}, true},
+		{[]Match{{Score: 0.52, Fuzzy: true}}, false},
+		{[]Match{{Score: 1}, {Score: 0.6}}, true},
+		{[]Match{{Score: 1}, {Score: 0.8}}, false},
+		{[]Match{{Score: 0.8, Fuzzy: true}, {Score: 0.5, Fuzzy: true}}, false},
+	}
+	for i, test := range tests {
+		if Dominant(test.matches) != test.dominant {
+			t.Errorf("%d: expected %v", i, test.dominant)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package contacts
+
+import (
+	"crypto/md5"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/xconstruct/vdir"
+)
+
+// Write an iCalendar (RFC 5545) with a yearly event for each birthday.
+// The UID of an event is derived from the UID of the card, so that
+// calendars recognize the events when the file is imported again.
+// With `alarm` > 0, a reminder is added that many days ahead.
+// `now` is used for the DTSTAMP of events.
+func WriteBirthdayCalendar(writer io.Writer, cards []vdir.Card, alarm int, now time.Time) error {
+	props := []property{
+		{Name: "BEGIN", Value: "VCALENDAR"},
+		{Name: "VERSION", Value: "2.0"},
+		{Name: "PRODID", Value: "-//akeil.net//contacts//EN"},
+		{Name: "CALSCALE", Value: "GREGORIAN"},
+	}
+	for _, card := range cards {
+		if card.Birthday == "" {
+			continue
+		}
+		date, err := ParseDate(card.Birthday)
+		if err == nil && !date.Yearly() {
+			err = fmt.Errorf("Month or day missing in %q", card.Birthday)
+		}
+		if err != nil {
+			log.Printf("Skip birthday of %v: %v", FormatName(card), err)
+			continue
+		}
+		props = append(props, birthdayEvent(card, date, alarm, now)...)
+	}
+	props = append(props, property{Name: "END", Value: "VCALENDAR"})
+	return writeProperties(writer, props)
+}
+
+func birthdayEvent(card vdir.Card, date Date, alarm int, now time.Time) []property {
+	name := strings.TrimSpace(FormatName(card))
+	uid := card.Uid
+	if uid == "" {
+		uid = fmt.Sprintf("%x", md5.Sum([]byte(name)))
+	}
+
+	// events need a start date, a leap year allows Feb 29
+	year := date.Year
+	if year == 0 {
+		year = 2000
+	}
+	rule := "FREQ=YEARLY"
+	if date.Month == time.February && date.Day == 29 {
+		// Feb 28 in other years
+		rule = "FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=-1"
+	}
+
+	summary := name + "'s birthday"
+	if date.Year != 0 {
+		summary += fmt.Sprintf(" (%d)", date.Year)
+	}
+	props := []property{
+		{Name: "BEGIN", Value: "VEVENT"},
+		{Name: "UID", Value: escapeText(uid + "-birthday")},
+		{Name: "DTSTAMP", Value: now.UTC().Format("20060102T150405Z")},
+		{Name: "DTSTART", Params: []param{{"VALUE", []string{"DATE"}}},
+			Value: fmt.Sprintf("%04d%02d%02d", year, date.Month, date.Day)},
+		{Name: "RRULE", Value: rule},
+		{Name: "SUMMARY", Value: escapeText(summary)},
+		{Name: "TRANSP", Value: "TRANSPARENT"},
+	}
+	if alarm > 0 {
+		props = append(props,
+			property{Name: "BEGIN", Value: "VALARM"},
+			property{Name: "ACTION", Value: "DISPLAY"},
+			property{Name: "DESCRIPTION", Value: escapeText(summary)},
+			property{Name: "TRIGGER", Value: fmt.Sprintf("-P%dD", alarm)},
+			property{Name: "END", Value: "VALARM"})
+	}
+	return append(props, property{Name: "END", Value: "VEVENT"})
+}
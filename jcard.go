@@ -0,0 +1,280 @@
+package contacts
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/xconstruct/vdir"
+)
+
+// jCard (RFC 7095) is the JSON representation of a vCard:
+//
+//	["vcard", [
+//	  ["version", {}, "text", "4.0"],
+//	  ["fn", {}, "text", "John Doe"],
+//	  ["email", {"type": "work"}, "text", "john.doe@example.com"]
+//	]]
+//
+// Several cards are written as a JSON array of jCards.
+
+// Write cards as an array of jCards
+func writeJCard(writer io.Writer, matches []Match, opts ExportOptions) error {
+	result := []interface{}{}
+	for _, match := range matches {
+		// jCard is defined for vCard 4.0 only
+		props, err := exportProperties(match, "4.0", opts)
+		if err != nil {
+			return err
+		}
+		result = append(result, jcard(props))
+	}
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(result)
+}
+
+func jcard(props []property) []interface{} {
+	jprops := []interface{}{}
+	for _, prop := range props {
+		jprops = append(jprops, jcardProperty(prop))
+	}
+	return []interface{}{"vcard", jprops}
+}
+
+func jcardProperty(prop property) []interface{} {
+	params := map[string]interface{}{}
+	if prop.Group != "" {
+		params["group"] = strings.ToLower(prop.Group)
+	}
+	for _, par := range prop.Params {
+		if par.Name == "VALUE" {
+			// expressed as the value type
+			continue
+		}
+		if len(par.Values) == 1 {
+			params[strings.ToLower(par.Name)] = par.Values[0]
+		} else {
+			params[strings.ToLower(par.Name)] = par.Values
+		}
+	}
+
+	vtype := valueType(prop)
+	result := []interface{}{strings.ToLower(prop.Name), params, vtype}
+	return append(result, jcardValues(prop.Name, vtype, prop.Value)...)
+}
+
+// convert a vCard value to one or more jCard values
+func jcardValues(name, vtype, value string) []interface{} {
+	if structuredProperties[name] && len(splitEscaped(value, ';')) > 1 {
+		components := []interface{}{}
+		for _, component := range splitEscaped(value, ';') {
+			parts := splitEscaped(component, ',')
+			if len(parts) == 1 {
+				components = append(components, unescapeText(component))
+			} else {
+				list := []interface{}{}
+				for _, part := range parts {
+					list = append(list, unescapeText(part))
+				}
+				components = append(components, list)
+			}
+		}
+		return []interface{}{components}
+	}
+
+	values := []interface{}{}
+	parts := []string{value}
+	if listProperties[name] {
+		parts = splitEscaped(value, ',')
+	}
+	for _, part := range parts {
+		values = append(values, jcardValue(vtype, part))
+	}
+	return values
+}
+
+func jcardValue(vtype, value string) interface{} {
+	switch {
+	case vtype == "text":
+		return unescapeText(value)
+	case isDateType(vtype):
+		return extendedDateTime(value)
+	case vtype == "integer" || vtype == "float":
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	case vtype == "boolean":
+		return strings.EqualFold(value, "TRUE")
+	}
+	return value
+}
+
+// Read jCard data, either a single jCard or an array of jCards.
+// Cards that cannot be converted are reported in the list of errors.
+func readJCard(reader io.Reader) ([]ImportedCard, []error, error) {
+	cards := []ImportedCard{}
+	failed := []error{}
+	var data interface{}
+	err := json.NewDecoder(reader).Decode(&data)
+	if err != nil {
+		return cards, failed, err
+	}
+
+	items, ok := data.([]interface{})
+	if !ok || len(items) == 0 {
+		return cards, failed, errors.New("Expected a jCard or an array of jCards")
+	}
+	if name, ok := items[0].(string); ok && name == "vcard" {
+		items = []interface{}{items}
+	}
+
+	for i, item := range items {
+		props, err := jcardProperties(item)
+		if err == nil {
+			var card vdir.Card
+			card, err = propertiesCard(props)
+			if err == nil {
+				cards = append(cards, ImportedCard{card, props})
+				continue
+			}
+		}
+		failed = append(failed, fmt.Errorf("jCard %d: %v", i+1, err))
+	}
+	return cards, failed, nil
+}
+
+// convert a single jCard to vCard properties
+func jcardProperties(item interface{}) ([]property, error) {
+	props := []property{}
+	parts, ok := item.([]interface{})
+	if !ok || len(parts) != 2 || parts[0] != "vcard" {
+		return props, errors.New(`expected ["vcard", [...]]`)
+	}
+	jprops, ok := parts[1].([]interface{})
+	if !ok {
+		return props, errors.New("expected a list of properties")
+	}
+
+	for _, jprop := range jprops {
+		prop, err := vcardProperty(jprop)
+		if err != nil {
+			return props, err
+		}
+		props = append(props, prop)
+	}
+	return props, nil
+}
+
+// convert a single jCard property to a vCard property
+func vcardProperty(item interface{}) (property, error) {
+	var prop property
+	parts, ok := item.([]interface{})
+	if !ok || len(parts) < 4 {
+		return prop, fmt.Errorf("invalid property %v", item)
+	}
+	name, ok1 := parts[0].(string)
+	params, ok2 := parts[1].(map[string]interface{})
+	vtype, ok3 := parts[2].(string)
+	if !ok1 || !ok2 || !ok3 {
+		return prop, fmt.Errorf("invalid property %v", item)
+	}
+	prop.Name = strings.ToUpper(name)
+
+	// sort for a stable order of parameters
+	keys := []string{}
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		values, err := jcardStrings(params[key])
+		if err != nil {
+			return prop, fmt.Errorf("parameter %q of %q: %v", key, name, err)
+		}
+		if key == "group" && len(values) == 1 {
+			prop.Group = values[0]
+		} else {
+			prop.Params = append(prop.Params, param{strings.ToUpper(key), values})
+		}
+	}
+	if vtype != "unknown" && vtype != defaultValueType(prop.Name) {
+		prop.Params = append(prop.Params, param{"VALUE", []string{vtype}})
+	}
+
+	value, err := vcardValue(prop.Name, vtype, parts[3:])
+	if err != nil {
+		return prop, fmt.Errorf("value of %q: %v", name, err)
+	}
+	prop.Value = value
+	return prop, nil
+}
+
+// convert jCard values to a (escaped) vCard value
+func vcardValue(name, vtype string, values []interface{}) (string, error) {
+	if structuredProperties[name] && len(values) == 1 {
+		if components, ok := values[0].([]interface{}); ok {
+			escaped := []string{}
+			for _, component := range components {
+				parts, err := jcardStrings(component)
+				if err != nil {
+					return "", err
+				}
+				for i := range parts {
+					parts[i] = escapeText(parts[i])
+				}
+				escaped = append(escaped, strings.Join(parts, ","))
+			}
+			return strings.Join(escaped, ";"), nil
+		}
+	}
+
+	escaped := []string{}
+	for _, value := range values {
+		s, err := jcardString(value)
+		if err != nil {
+			return "", err
+		}
+		if vtype == "text" {
+			s = escapeText(s)
+		} else if isDateType(vtype) {
+			s = basicDateTime(s)
+		}
+		escaped = append(escaped, s)
+	}
+	return strings.Join(escaped, ","), nil
+}
+
+// a single JSON value as string
+func jcardString(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		return strings.ToUpper(strconv.FormatBool(v)), nil
+	}
+	return "", fmt.Errorf("unexpected value %v", value)
+}
+
+// a JSON value or an array of values as strings
+func jcardStrings(value interface{}) ([]string, error) {
+	list, ok := value.([]interface{})
+	if !ok {
+		list = []interface{}{value}
+	}
+	result := []string{}
+	for _, item := range list {
+		s, err := jcardString(item)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, s)
+	}
+	return result, nil
+}
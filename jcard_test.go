@@ -0,0 +1,200 @@
+package contacts
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// the example from RFC 7095, Appendix B.1
+const rfc7095Example = `["vcard",
+  [
+    ["version", {}, "text", "4.0"],
+    ["fn", {}, "text", "Simon Perreault"],
+    ["n",
+      {},
+      "text",
+      ["Perreault", "Simon", "", "", ["ing. jr", "M.Sc."]]
+    ],
+    ["bday", {}, "date-and-or-time", "--02-03"],
+    ["anniversary",
+      {},
+      "date-and-or-time",
+      "2009-08-08T14:30:00-05:00"
+    ],
+    ["gender", {}, "text", "M"],
+    ["lang", { "pref": "1" }, "language-tag", "fr"],
+    ["lang", { "pref": "2" }, "language-tag", "en"],
+    ["org", { "type": "work" }, "text", "Viagenie"],
+    ["adr",
+       { "type": "work" },
+       "text",
+       [
+        "",
+        "Suite D2-630",
+        "2875 Laurier",
+        "Quebec",
+        "QC",
+        "G1V 2M2",
+        "Canada"
+       ]
+    ],
+    ["tel",
+      { "type": ["work", "voice"], "pref": "1" },
+      "uri",
+      "tel:+1-418-656-9254;ext=102"
+    ],
+    ["tel",
+      { "type": ["work", "cell", "voice", "video", "text"] },
+      "uri",
+      "tel:+1-418-262-6501"
+    ],
+    ["email",
+      { "type": "work" },
+      "text",
+      "simon.perreault@viagenie.ca"
+    ],
+    ["geo", { "type": "work" }, "uri", "geo:46.772673,-71.282945"],
+    ["key",
+      { "type": "work" },
+      "uri",
+      "http://www.viagenie.ca/simon.perreault/simon.asc"
+    ],
+    ["tz", {}, "utc-offset", "-05:00"],
+    ["url", { "type": "home" }, "uri", "http://nomis80.org"]
+  ]
+]`
+
+// Convert the example to vCard and back, the result should be the same jCard.
+func TestJCardRoundTrip(t *testing.T) {
+	var original interface{}
+	err := json.Unmarshal([]byte(rfc7095Example), &original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	props, err := jcardProperties(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var vcard bytes.Buffer
+	err = writeProperties(&vcard, props)
+	if err != nil {
+		t.Fatal(err)
+	}
+	props, err = parseProperties(vcard.Bytes())
+	if err != nil {
+		t.Fatalf("%v\n%s", err, vcard.String())
+	}
+
+	data, err := json.Marshal(jcard(props))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result interface{}
+	err = json.Unmarshal(data, &result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(original, result) {
+		t.Errorf("round trip changed the jCard\nvCard:\n%s\njCard:\n%s", vcard.String(), data)
+	}
+}
+
+// Import the example into an address book and export it again:
+// parameters and properties that vdir does not know are kept.
+func TestJCardImportExport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "contacts-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cards, failed, err := ReadCards(strings.NewReader(rfc7095Example), "jcard", ImportOptions{})
+	if err != nil || len(failed) > 0 {
+		t.Fatal(err, failed)
+	}
+	if len(cards) != 1 || cards[0].FormattedName != "Simon Perreault" {
+		t.Fatalf("Expected the card of Simon Perreault, got %v", cards)
+	}
+	book := NewAddressbook(dir)
+	err = book.Import(cards[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// from the file, as a new command would do
+	book = NewAddressbook(dir)
+	matches, err := book.Rank(Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	err = WriteCards(&buf, matches, "jcard", ExportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var original, result interface{}
+	json.Unmarshal([]byte(rfc7095Example), &original)
+	err = json.Unmarshal(buf.Bytes(), &result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an array of jCards, the UID is assigned on import
+	list, ok := result.([]interface{})
+	if !ok || len(list) != 1 {
+		t.Fatalf("Expected one jCard, got %s", buf.String())
+	}
+	exported := list[0].([]interface{})
+	props := []interface{}{}
+	for _, prop := range exported[1].([]interface{}) {
+		if prop.([]interface{})[0] != "uid" {
+			props = append(props, prop)
+		}
+	}
+	exported[1] = props
+	if !reflect.DeepEqual(original, exported) {
+		t.Errorf("Export differs from the imported jCard:\n%s", buf.String())
+	}
+}
+
+func TestJCardProperty(t *testing.T) {
+	tests := []struct {
+		jcard string
+		vcard string
+	}{
+		{`["fn", {}, "text", "Simon Perreault"]`, "FN:Simon Perreault\r\n"},
+		{`["note", {}, "text", "a, b; c"]`, "NOTE:a\\, b\\; c\r\n"},
+		{`["bday", {}, "date-and-or-time", "--02-03"]`, "BDAY:--0203\r\n"},
+		{`["bday", {}, "date", "1985-04-12"]`, "BDAY;VALUE=date:19850412\r\n"},
+		{`["tel", {"type": ["work", "voice"]}, "uri", "tel:+1-418-656-9254"]`,
+			"TEL;TYPE=work,voice;VALUE=uri:tel:+1-418-656-9254\r\n"},
+		{`["email", {"group": "item1"}, "text", "a@example.com"]`, "item1.EMAIL:a@example.com\r\n"},
+	}
+	for _, test := range tests {
+		var item interface{}
+		err := json.Unmarshal([]byte(test.jcard), &item)
+		if err != nil {
+			t.Fatal(err)
+		}
+		prop, err := vcardProperty(item)
+		if err != nil {
+			t.Errorf("%s: %v", test.jcard, err)
+			continue
+		}
+		var buf bytes.Buffer
+		err = writeProperties(&buf, []property{prop})
+		if err != nil {
+			t.Errorf("%s: %v", test.jcard, err)
+			continue
+		}
+		if buf.String() != test.vcard {
+			t.Errorf("%s: got %q, want %q", test.jcard, buf.String(), test.vcard)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package contacts
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/xconstruct/vdir"
+)
+
+// The JSON representation of a card, see docs/json.md.
+// All lists are always present (empty if not set),
+// all strings are present (empty if not set).
+type JSONCard struct {
+	Uid           string        `json:"uid"`
+	Rev           string        `json:"rev"`
+	FormattedName string        `json:"formatted_name"`
+	Name          JSONName      `json:"name"`
+	NickNames     []string      `json:"nicknames"`
+	Emails        []JSONTyped   `json:"emails"`
+	Phones        []JSONTyped   `json:"phones"`
+	Urls          []JSONTyped   `json:"urls"`
+	Addresses     []JSONAddress `json:"addresses"`
+	Categories    []string      `json:"categories"`
+	Org           string        `json:"org"`
+	Title         string        `json:"title"`
+	Role          string        `json:"role"`
+	Birthday      string        `json:"birthday"`
+	Anniversary   string        `json:"anniversary"`
+	Note          string        `json:"note"`
+	Photo         string        `json:"photo"`
+	Gender        string        `json:"gender"`
+	Book          string        `json:"book"`
+}
+
+type JSONName struct {
+	Family     []string `json:"family"`
+	Given      []string `json:"given"`
+	Additional []string `json:"additional"`
+	Prefixes   []string `json:"prefixes"`
+	Suffixes   []string `json:"suffixes"`
+}
+
+type JSONTyped struct {
+	Types []string `json:"types"`
+	Value string   `json:"value"`
+}
+
+type JSONAddress struct {
+	Types           []string `json:"types"`
+	Label           string   `json:"label"`
+	PostOfficeBox   string   `json:"po_box"`
+	ExtendedAddress string   `json:"extended"`
+	Street          string   `json:"street"`
+	Locality        string   `json:"locality"`
+	Region          string   `json:"region"`
+	PostalCode      string   `json:"postal_code"`
+	Country         string   `json:"country"`
+}
+
+// Convert a card to its JSON representation
+func NewJSONCard(card vdir.Card) JSONCard {
+	j := JSONCard{
+		Uid:           card.Uid,
+		Rev:           card.Rev,
+		FormattedName: FormatName(card),
+		Name: JSONName{
+			Family:     list(card.Name.FamilyName),
+			Given:      list(card.Name.GivenName),
+			Additional: list(card.Name.AdditionalNames),
+			Prefixes:   list(card.Name.HonorificNames),
+			Suffixes:   list(card.Name.HonorificSuffixes),
+		},
+		NickNames:   list(card.NickName),
+		Emails:      jsonTyped(card.Email),
+		Phones:      jsonTyped(card.Telephones),
+		Urls:        jsonTyped(card.Url),
+		Addresses:   []JSONAddress{},
+		Categories:  list(card.Categories),
+		Org:         card.Org,
+		Title:       card.Title,
+		Role:        card.Role,
+		Birthday:    card.Birthday,
+		Anniversary: card.Anniversary,
+		Note:        card.Note,
+		Photo:       card.Photo,
+		Gender:      card.Gender,
+	}
+	for _, a := range card.Addresses {
+		j.Addresses = append(j.Addresses, JSONAddress{
+			list(a.Type), a.Label, a.PostOfficeBox, a.ExtendedAddress,
+			a.Street, a.Locality, a.Region, a.PostalCode, a.CountryName,
+		})
+	}
+	return j
+}
+
+// like NewJSONCard, with the name of the address book
+func jsonMatch(match Match) JSONCard {
+	j := NewJSONCard(match.Card)
+	if match.Book != nil {
+		j.Book = match.Book.Name
+	}
+	return j
+}
+
+func jsonTyped(tvalues []vdir.TypedValue) []JSONTyped {
+	result := []JSONTyped{}
+	for _, tv := range tvalues {
+		result = append(result, JSONTyped{list(tv.Type), tv.Value})
+	}
+	return result
+}
+
+// never nil, so that empty lists are rendered as `[]`
+func list(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
+
+// Write cards as a single JSON array
+func writeJSON(writer io.Writer, matches []Match) error {
+	result := []JSONCard{}
+	for _, match := range matches {
+		result = append(result, jsonMatch(match))
+	}
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(result)
+}
+
+// Write cards as newline delimited JSON, one object per line
+func writeNDJSON(writer io.Writer, matches []Match) error {
+	encoder := json.NewEncoder(writer)
+	for _, match := range matches {
+		err := encoder.Encode(jsonMatch(match))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,476 @@
+package contacts
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/xconstruct/vdir"
+)
+
+// LDIF (RFC 2849) as exported by Thunderbird and LDAP directories:
+//
+//	dn: cn=John Doe,mail=john.doe@example.com
+//	objectclass: inetOrgPerson
+//	objectclass: mozillaAbPersonAlpha
+//	cn: John Doe
+//	givenName: John
+//	sn: Doe
+//	mail: john.doe@example.com
+//
+// Attributes are from inetOrgPerson and Thunderbird's mozillaAbPersonAlpha.
+
+var ldifObjectClasses = []string{
+	"top", "person", "organizationalPerson", "inetOrgPerson", "mozillaAbPersonAlpha",
+}
+
+// phone attributes by vCard type
+var ldifPhones = []struct {
+	attr string
+	kind string
+}{
+	{"mobile", "cell"},
+	{"facsimileTelephoneNumber", "fax"},
+	{"pager", "pager"},
+	{"homePhone", "home"},
+	{"telephoneNumber", "work"},
+}
+
+// address attributes for home and work
+// (pobox, street, street 2, locality, region, postal code, country)
+var ldifAddresses = map[string][]string{
+	"home": {"", "mozillaHomeStreet", "mozillaHomeStreet2", "mozillaHomeLocalityName",
+		"mozillaHomeState", "mozillaHomePostalCode", "mozillaHomeCountryName"},
+	"work": {"postOfficeBox", "street", "mozillaWorkStreet2", "l",
+		"st", "postalCode", "c"},
+}
+
+// Write cards as LDIF records
+func writeLDIF(writer io.Writer, cards []vdir.Card, opts ExportOptions) error {
+	if stripped("FN", opts.Strip) {
+		return fmt.Errorf("Cannot leave out FN in LDIF, it is the name of each entry")
+	}
+	w := &ldifWriter{writer: bufio.NewWriter(writer)}
+	w.line("version", "1")
+	for _, card := range cards {
+		w.blank()
+		w.card(card, opts)
+	}
+	if w.err != nil {
+		return w.err
+	}
+	return w.writer.Flush()
+}
+
+// keeps the first error, see xcardWriter
+type ldifWriter struct {
+	writer *bufio.Writer
+	err    error
+}
+
+func (w *ldifWriter) blank() {
+	if w.err == nil {
+		_, w.err = w.writer.WriteString("\n")
+	}
+}
+
+// write "attr: value", base64 encoded if needed, folded after 76 columns
+func (w *ldifWriter) line(attr, value string) {
+	if w.err != nil || value == "" {
+		return
+	}
+	var line string
+	if ldifSafe(value) {
+		line = attr + ": " + value
+	} else {
+		line = attr + ":: " + base64.StdEncoding.EncodeToString([]byte(value))
+	}
+	// lines are ASCII only,
+	// continuation lines have a leading space and 75 characters
+	width := 76
+	for len(line) > width && w.err == nil {
+		_, w.err = w.writer.WriteString(line[:width] + "\n ")
+		line = line[width:]
+		width = 75
+	}
+	if w.err == nil {
+		_, w.err = w.writer.WriteString(line + "\n")
+	}
+}
+
+// values that can be written without base64 (SAFE-STRING in RFC 2849)
+func ldifSafe(value string) bool {
+	if strings.HasPrefix(value, " ") || strings.HasPrefix(value, ":") ||
+		strings.HasPrefix(value, "<") || strings.HasSuffix(value, " ") {
+		return false
+	}
+	for _, r := range value {
+		if r > 127 || r == 0 || r == '\r' || r == '\n' {
+			return false
+		}
+	}
+	return true
+}
+
+func (w *ldifWriter) card(card vdir.Card, opts ExportOptions) {
+	strip := func(name string) bool {
+		return stripped(name, opts.Strip)
+	}
+	name := strings.TrimSpace(FormatName(card))
+	dn := "cn=" + ldifEscapeDN(name)
+	if mail := PrimaryMail(card); mail != "" && !strip("EMAIL") {
+		dn += ",mail=" + ldifEscapeDN(mail)
+	}
+	w.line("dn", dn)
+	for _, class := range ldifObjectClasses {
+		w.line("objectclass", class)
+	}
+	w.line("cn", name)
+	if !strip("N") {
+		w.line("givenName", strings.Join(card.Name.GivenName, " "))
+		w.line("sn", strings.Join(card.Name.FamilyName, " "))
+	}
+	if !strip("NICKNAME") {
+		w.line("mozillaNickname", strings.Join(card.NickName, ","))
+	}
+
+	if !strip("EMAIL") {
+		for i, mail := range card.Email {
+			if i == 1 {
+				w.line("mozillaSecondEmail", mail.Value)
+			} else {
+				w.line("mail", mail.Value)
+			}
+		}
+	}
+	if !strip("TEL") {
+		for _, tel := range card.Telephones {
+			w.line(ldifPhoneAttr(tel.Type), tel.Value)
+		}
+	}
+
+	addresses := card.Addresses
+	if strip("ADR") {
+		addresses = nil
+	}
+	done := map[string]bool{}
+	for _, adr := range addresses {
+		kind := "work"
+		if hasType(adr.Type, "home") {
+			kind = "home"
+		}
+		if done[kind] {
+			continue
+		}
+		done[kind] = true
+		attrs := ldifAddresses[kind]
+		street := strings.SplitN(adr.Street, "\n", 2)
+		values := []string{adr.PostOfficeBox, street[0], "", adr.Locality,
+			adr.Region, adr.PostalCode, adr.CountryName}
+		if len(street) > 1 {
+			values[2] = strings.Replace(street[1], "\n", ", ", -1)
+		}
+		for i, attr := range attrs {
+			if attr != "" {
+				w.line(attr, values[i])
+			}
+		}
+	}
+
+	if !strip("URL") {
+		for _, url := range card.Url {
+			if hasType(url.Type, "home") {
+				w.line("mozillaHomeUrl", url.Value)
+			} else {
+				w.line("mozillaWorkUrl", url.Value)
+			}
+		}
+	}
+
+	if !strip("ORG") {
+		w.line("o", card.Org)
+	}
+	if !strip("TITLE") {
+		w.line("title", card.Title)
+	}
+	if !strip("BDAY") {
+		year, month, day := ldifBirthday(card.Birthday)
+		w.line("birthyear", year)
+		w.line("birthmonth", month)
+		w.line("birthday", day)
+	}
+	if !strip("NOTE") {
+		w.line("description", card.Note)
+	}
+}
+
+func ldifPhoneAttr(types []string) string {
+	for _, phone := range ldifPhones {
+		if hasType(types, phone.kind) {
+			return phone.attr
+		}
+	}
+	return "telephoneNumber"
+}
+
+func hasType(types []string, wanted string) bool {
+	for _, t := range types {
+		if strings.EqualFold(t, wanted) {
+			return true
+		}
+	}
+	return false
+}
+
+// escape special characters in a DN value (RFC 4514)
+func ldifEscapeDN(value string) string {
+	var escaped strings.Builder
+	for i, r := range value {
+		if strings.ContainsRune(",+\"\\<>;=", r) ||
+			(i == 0 && (r == ' ' || r == '#')) ||
+			(i == len(value)-1 && r == ' ') {
+			escaped.WriteRune('\\')
+		}
+		escaped.WriteRune(r)
+	}
+	return escaped.String()
+}
+
+// split a vCard date (19850415, --0415) into year, month and day
+func ldifBirthday(value string) (string, string, string) {
+	value = basicDateTime(value)
+	if groups := basicDateRegex.FindStringSubmatch(value); groups != nil {
+		return groups[1], groups[2], groups[3]
+	} else if groups := basicMonthDay.FindStringSubmatch(value); groups != nil {
+		return "", groups[1], groups[2]
+	}
+	return "", "", ""
+}
+
+// an LDIF record, attribute names are lower case
+type ldifRecord struct {
+	line  int
+	attrs map[string][]string
+}
+
+func (r ldifRecord) first(attr string) string {
+	values := r.attrs[strings.ToLower(attr)]
+	if len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// Read LDIF records, each record with a person becomes a card.
+// Records that cannot be read are reported in the list of errors.
+func readLDIF(reader io.Reader) ([]vdir.Card, []error, error) {
+	cards := []vdir.Card{}
+	failed := []error{}
+	records, err := parseLDIF(reader)
+	if err != nil {
+		return cards, failed, err
+	}
+	for _, record := range records {
+		if record.attrs == nil {
+			failed = append(failed, &SyntaxError{record.line, "invalid record"})
+			continue
+		}
+		if _, ok := record.attrs["dn"]; !ok {
+			// e.g. "version: 1"
+			continue
+		}
+		if ldifGroup(record) {
+			// mailing lists in Thunderbird
+			continue
+		}
+		if record.first("changetype") != "" && record.first("changetype") != "add" {
+			failed = append(failed, &SyntaxError{record.line, "only changetype add is supported"})
+			continue
+		}
+		cards = append(cards, ldifCard(record))
+	}
+	return cards, failed, nil
+}
+
+func ldifGroup(record ldifRecord) bool {
+	for _, class := range record.attrs["objectclass"] {
+		if strings.EqualFold(class, "groupOfNames") || strings.EqualFold(class, "groupOfUniqueNames") {
+			return true
+		}
+	}
+	return false
+}
+
+// split LDIF data into records, records with invalid lines have no attrs
+func parseLDIF(reader io.Reader) ([]ldifRecord, error) {
+	records := []ldifRecord{}
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	var current *ldifRecord
+	var lines []string
+	lineno := 0
+	flush := func() {
+		if current == nil {
+			return
+		}
+		for _, line := range lines {
+			if !current.add(line) {
+				current.attrs = nil
+				break
+			}
+		}
+		records = append(records, *current)
+		current = nil
+		lines = nil
+	}
+
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		switch {
+		case line == "":
+			flush()
+		case strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, " "):
+			if len(lines) > 0 {
+				lines[len(lines)-1] += line[1:]
+			}
+		default:
+			if current == nil {
+				current = &ldifRecord{lineno, map[string][]string{}}
+			}
+			lines = append(lines, line)
+		}
+	}
+	flush()
+	return records, scanner.Err()
+}
+
+// add an "attr: value" line, false if the line is invalid
+func (r *ldifRecord) add(line string) bool {
+	colon := strings.Index(line, ":")
+	if colon <= 0 {
+		return false
+	}
+	// attribute options like ";lang-de" are ignored
+	attr := strings.ToLower(strings.SplitN(line[:colon], ";", 2)[0])
+	value := line[colon+1:]
+	if strings.HasPrefix(value, ":") {
+		data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value[1:]))
+		if err != nil {
+			return false
+		}
+		value = string(data)
+	} else if strings.HasPrefix(value, "<") {
+		// URLs are not supported
+		return false
+	} else {
+		value = strings.TrimLeft(value, " ")
+	}
+	r.attrs[attr] = append(r.attrs[attr], value)
+	return true
+}
+
+func ldifCard(record ldifRecord) vdir.Card {
+	var card vdir.Card
+	card.FormattedName = record.first("cn")
+	if card.FormattedName == "" {
+		card.FormattedName = record.first("displayName")
+	}
+	if v := record.first("givenName"); v != "" {
+		card.Name.GivenName = []string{v}
+	}
+	if v := record.first("sn"); v != "" {
+		card.Name.FamilyName = []string{v}
+	}
+	card.NickName = multiple(record.first("mozillaNickname"))
+
+	for i, mail := range record.attrs["mail"] {
+		card.Email = append(card.Email, vdir.TypedValue{Value: mail})
+		if i == 0 {
+			for _, second := range record.attrs["mozillasecondemail"] {
+				card.Email = append(card.Email, vdir.TypedValue{Value: second})
+			}
+		}
+	}
+	if len(record.attrs["mail"]) == 0 {
+		for _, second := range record.attrs["mozillasecondemail"] {
+			card.Email = append(card.Email, vdir.TypedValue{Value: second})
+		}
+	}
+
+	// in the order of the vCard properties, work first
+	for i := len(ldifPhones) - 1; i >= 0; i-- {
+		phone := ldifPhones[i]
+		for _, value := range record.attrs[strings.ToLower(phone.attr)] {
+			card.Telephones = append(card.Telephones,
+				vdir.TypedValue{Type: []string{phone.kind}, Value: value})
+		}
+	}
+
+	for _, kind := range []string{"home", "work"} {
+		attrs := ldifAddresses[kind]
+		values := make([]string, len(attrs))
+		empty := true
+		for i, attr := range attrs {
+			if attr != "" {
+				values[i] = record.first(attr)
+				empty = empty && values[i] == ""
+			}
+		}
+		if empty {
+			continue
+		}
+		street := values[1]
+		if values[2] != "" {
+			street += "\n" + values[2]
+		}
+		card.Addresses = append(card.Addresses, vdir.Address{
+			Type:          []string{kind},
+			PostOfficeBox: values[0],
+			Street:        street,
+			Locality:      values[3],
+			Region:        values[4],
+			PostalCode:    values[5],
+			CountryName:   values[6],
+		})
+	}
+
+	for _, attr := range []string{"mozillaWorkUrl", "mozillaHomeUrl", "labeledURI"} {
+		for _, value := range record.attrs[strings.ToLower(attr)] {
+			tv := vdir.TypedValue{Value: value}
+			switch attr {
+			case "mozillaWorkUrl":
+				tv.Type = []string{"work"}
+			case "mozillaHomeUrl":
+				tv.Type = []string{"home"}
+			case "labeledURI":
+				// URI followed by an optional label
+				tv.Value = strings.SplitN(strings.TrimSpace(value), " ", 2)[0]
+			}
+			card.Url = append(card.Url, tv)
+		}
+	}
+
+	card.Org = record.first("o")
+	card.Title = record.first("title")
+	card.Note = record.first("description")
+	card.Birthday = ldifDate(record.first("birthyear"),
+		record.first("birthmonth"), record.first("birthday"))
+	return card
+}
+
+// a vCard date from year, month and day, the year is optional
+func ldifDate(year, month, day string) string {
+	if month == "" || day == "" {
+		return ""
+	}
+	date := fmt.Sprintf("%02s%02s", month, day)
+	if year == "" {
+		return "--" + date
+	}
+	return fmt.Sprintf("%04s", year) + date
+}
@@ -0,0 +1,33 @@
+package contacts
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/xconstruct/vdir"
+)
+
+func TestLDIFLongValue(t *testing.T) {
+	// base64 encoded, much longer than one line
+	note := strings.Repeat("Grüße aus Köln, ", 20)
+	card := vdir.Card{FormattedName: "John Doe", Note: note}
+	var buf bytes.Buffer
+	err := writeLDIF(&buf, []vdir.Card{card}, ExportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if len(line) > 76 {
+			t.Errorf("Line longer than 76 columns: %q", line)
+		}
+	}
+
+	cards, failed, err := readLDIF(&buf)
+	if err != nil || len(failed) > 0 {
+		t.Fatal(err, failed)
+	}
+	if len(cards) != 1 || cards[0].Note != note {
+		t.Errorf("Expected the note %q, got %v", note, cards)
+	}
+}
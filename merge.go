@@ -0,0 +1,192 @@
+package contacts
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/xconstruct/vdir"
+)
+
+// A field that has different values in cards that are merged
+type MergeConflict struct {
+	Field  string // the label in the editor, e.g. "Organization"
+	Values []string
+}
+
+// single value fields by their label in the editor.
+// Anniversary, Gender and Photo are not in the editor,
+// for these, the first value is kept.
+var mergeFields = []struct {
+	label string
+	field func(card *vdir.Card) *string
+}{
+	{"Title", func(card *vdir.Card) *string { return &card.Title }},
+	{"Role", func(card *vdir.Card) *string { return &card.Role }},
+	{"Organization", func(card *vdir.Card) *string { return &card.Org }},
+	{"Birthday", func(card *vdir.Card) *string { return &card.Birthday }},
+	{"Note", func(card *vdir.Card) *string { return &card.Note }},
+	{"", func(card *vdir.Card) *string { return &card.Anniversary }},
+	{"", func(card *vdir.Card) *string { return &card.Gender }},
+	{"", func(card *vdir.Card) *string { return &card.Photo }},
+}
+
+// Merge several cards into one.
+// The result has the UID of the first card.
+// Mail addresses, phone numbers, URLs, postal addresses, nick names
+// and categories are joined, exact duplicates are left out
+// (if only the types differ, the types are joined).
+// For single values like the name or organization, the first non-empty
+// value is used; fields with different values are returned as conflicts.
+func MergeCards(cards []vdir.Card) (vdir.Card, []MergeConflict) {
+	conflicts := []MergeConflict{}
+	if len(cards) == 0 {
+		return vdir.Card{}, conflicts
+	}
+	merged := cards[0]
+	merged.NickName = nil
+	merged.Categories = nil
+	merged.Email = nil
+	merged.Telephones = nil
+	merged.Url = nil
+	merged.Addresses = nil
+	for _, card := range cards {
+		merged.NickName = mergeStrings(merged.NickName, card.NickName)
+		merged.Categories = mergeStrings(merged.Categories, card.Categories)
+		merged.Email = mergeTypedValues(merged.Email, card.Email)
+		merged.Telephones = mergeTypedValues(merged.Telephones, card.Telephones)
+		merged.Url = mergeTypedValues(merged.Url, card.Url)
+		merged.Addresses = mergeAddresses(merged.Addresses, card.Addresses)
+	}
+
+	// the name counts as a whole, parts of different names do not mix
+	names := []string{}
+	for _, card := range cards {
+		name := strings.TrimSpace(FormatName(card))
+		if name == "" {
+			continue
+		}
+		if len(names) == 0 {
+			merged.Name = card.Name
+			merged.FormattedName = card.FormattedName
+		}
+		names = mergeStrings(names, []string{name})
+	}
+	if len(names) > 1 {
+		conflicts = append(conflicts, MergeConflict{"Name", names})
+	}
+
+	for _, f := range mergeFields {
+		values := []string{}
+		for i := range cards {
+			if value := *f.field(&cards[i]); strings.TrimSpace(value) != "" {
+				values = mergeStrings(values, []string{value})
+			}
+		}
+		if len(values) == 0 {
+			continue
+		}
+		*f.field(&merged) = values[0]
+		if len(values) > 1 {
+			if f.label == "" {
+				log.Printf("Merge %v: keep %q", FormatName(merged), values[0])
+				continue
+			}
+			conflicts = append(conflicts, MergeConflict{f.label, values})
+		}
+	}
+	return merged, conflicts
+}
+
+// append the strings from `add` that are not in `list`
+func mergeStrings(list, add []string) []string {
+	for _, s := range add {
+		if s == "" {
+			continue
+		}
+		found := false
+		for _, existing := range list {
+			if existing == s {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+func mergeTypedValues(list, add []vdir.TypedValue) []vdir.TypedValue {
+	for _, tv := range add {
+		if tv.Value == "" {
+			continue
+		}
+		found := false
+		for i := range list {
+			if list[i].Value == tv.Value {
+				list[i].Type = mergeStrings(list[i].Type, tv.Type)
+				found = true
+				break
+			}
+		}
+		if !found {
+			tv.Type = mergeStrings(nil, tv.Type)
+			list = append(list, tv)
+		}
+	}
+	return list
+}
+
+func mergeAddresses(list, add []vdir.Address) []vdir.Address {
+	for _, adr := range add {
+		found := false
+		for i := range list {
+			if sameAddress(list[i], adr) {
+				list[i].Type = mergeStrings(list[i].Type, adr.Type)
+				found = true
+				break
+			}
+		}
+		if !found {
+			adr.Type = mergeStrings(nil, adr.Type)
+			list = append(list, adr)
+		}
+	}
+	return list
+}
+
+// compare addresses without the type
+func sameAddress(a, b vdir.Address) bool {
+	return a.Label == b.Label &&
+		a.PostOfficeBox == b.PostOfficeBox &&
+		a.ExtendedAddress == b.ExtendedAddress &&
+		a.Street == b.Street &&
+		a.Locality == b.Locality &&
+		a.Region == b.Region &&
+		a.PostalCode == b.PostalCode &&
+		a.CountryName == b.CountryName
+}
+
+// Like EditCard, but list the different values of fields
+// as a comment above the merged card.
+func EditMerge(cfg Configuration, card *vdir.Card, conflicts []MergeConflict) (bool, error) {
+	var header bytes.Buffer
+	header.WriteString("# Merged contacts have different values for these fields:\n")
+	for _, conflict := range conflicts {
+		header.WriteString("#\n")
+		for _, value := range conflict.Values {
+			scanner := bufio.NewScanner(strings.NewReader(value))
+			prefix := fmt.Sprintf("# %-12s : ", conflict.Field)
+			for scanner.Scan() {
+				header.WriteString(prefix + scanner.Text() + "\n")
+				prefix = fmt.Sprintf("# %-12s   ", "")
+			}
+		}
+	}
+	header.WriteString("#\n# The first value is used, edit the contact below to change it.\n\n")
+	return editCard(cfg, card, header.Bytes())
+}
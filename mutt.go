@@ -0,0 +1,137 @@
+package contacts
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/xconstruct/vdir"
+)
+
+// Write the result of a query in the format for mutt's query_command:
+// a line with a message, then one line per mail address with
+// address, name and the type of the address, separated by tabs.
+func WriteMuttQuery(writer io.Writer, cards []vdir.Card) error {
+	lines := []string{}
+	for _, card := range cards {
+		name := strings.TrimSpace(FormatName(card))
+		for _, mail := range card.Email {
+			if mail.Value == "" {
+				continue
+			}
+			lines = append(lines, strings.Join([]string{
+				muttField(mail.Value), muttField(name), muttField(strings.Join(mail.Type, ","))}, "\t"))
+		}
+	}
+
+	_, err := fmt.Fprintf(writer, "Found %v address(es).\n", len(lines))
+	for _, line := range lines {
+		if err != nil {
+			break
+		}
+		_, err = fmt.Fprintln(writer, line)
+	}
+	return err
+}
+
+// tabs and line breaks would break the format
+func muttField(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// A mutt alias for a mail address
+type MuttAlias struct {
+	Alias string
+	Name  string
+	Email string
+}
+
+func (a MuttAlias) String() string {
+	name := a.Name
+	if strings.ContainsAny(name, "\"(),.:;<>@[\\]") {
+		name = "\"" + strings.NewReplacer("\\", "\\\\", "\"", "\\\"").Replace(name) + "\""
+	}
+	if name == "" {
+		return fmt.Sprintf("alias %v <%v>", a.Alias, a.Email)
+	}
+	return fmt.Sprintf("alias %v %v <%v>", a.Alias, name, a.Email)
+}
+
+// Create mutt aliases from the (first) nickname of cards.
+// The first mail address of a card gets the nickname as alias,
+// other addresses get a number ("bob-2").
+// If several cards have the same nickname, the family name is added
+// ("bob-smith"), and if that does not help, a number.
+// Cards without nickname or mail address are skipped.
+// Collisions are returned as a list of messages.
+func MuttAliases(cards []vdir.Card) ([]MuttAlias, []string) {
+	sorted := make([]vdir.Card, len(cards))
+	copy(sorted, cards)
+	sort.Sort(ByName(sorted))
+
+	// count cards per nickname to find collisions
+	count := map[string]int{}
+	for _, card := range sorted {
+		if nick := muttAliasName(firstValue(card.NickName)); nick != "" && PrimaryMail(card) != "" {
+			count[nick]++
+		}
+	}
+
+	aliases := []MuttAlias{}
+	collisions := []string{}
+	used := map[string]bool{}
+	for _, card := range sorted {
+		nick := muttAliasName(firstValue(card.NickName))
+		if nick == "" || PrimaryMail(card) == "" {
+			continue
+		}
+		name := strings.TrimSpace(FormatName(card))
+		alias := nick
+		if count[nick] > 1 {
+			family := muttAliasName(strings.Join(card.Name.FamilyName, " "))
+			if family != "" {
+				alias = nick + "-" + family
+			}
+			collisions = append(collisions,
+				fmt.Sprintf("Nickname %q is used by %d contacts, using %q for %v",
+					nick, count[nick], alias, name))
+		}
+		alias = muttFreeAlias(alias, used)
+
+		n := 0
+		for _, mail := range card.Email {
+			if mail.Value == "" {
+				continue
+			}
+			n++
+			a := alias
+			if n > 1 {
+				a = muttFreeAlias(fmt.Sprintf("%v-%d", alias, n), used)
+			}
+			used[a] = true
+			aliases = append(aliases, MuttAlias{a, name, mail.Value})
+		}
+	}
+	return aliases, collisions
+}
+
+// add a number to an alias that is already used
+func muttFreeAlias(alias string, used map[string]bool) string {
+	candidate := alias
+	for i := 2; used[candidate]; i++ {
+		candidate = fmt.Sprintf("%v-%d", alias, i)
+	}
+	return candidate
+}
+
+// an alias without spaces, accents or special characters
+func muttAliasName(s string) string {
+	var b strings.Builder
+	for _, r := range foldString(strings.Join(strings.Fields(s), "-")) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || strings.ContainsRune("-_.", r) {
+			b.WriteRune(r)
+		}
+	}
+	return strings.Trim(b.String(), "-")
+}
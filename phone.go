@@ -0,0 +1,300 @@
+package contacts
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/xconstruct/vdir"
+)
+
+// Dialing conventions for a region:
+// the country calling code, the trunk prefix for national calls
+// and the prefix for international calls.
+type phoneRegion struct {
+	Code  string
+	Trunk string
+	IDD   string
+}
+
+// Regions by ISO 3166 code.
+// Regions without a trunk prefix (e.g. Italy) keep the leading zero.
+// In North America, a leading "1" is dropped from 11 digit numbers.
+var phoneRegions = map[string]phoneRegion{
+	"AT": {"43", "0", "00"},
+	"AU": {"61", "0", "0011"},
+	"BE": {"32", "0", "00"},
+	"CA": {"1", "", "011"},
+	"CH": {"41", "0", "00"},
+	"CZ": {"420", "", "00"},
+	"DE": {"49", "0", "00"},
+	"DK": {"45", "", "00"},
+	"ES": {"34", "", "00"},
+	"FI": {"358", "0", "00"},
+	"FR": {"33", "0", "00"},
+	"GB": {"44", "0", "00"},
+	"GR": {"30", "", "00"},
+	"IE": {"353", "0", "00"},
+	"IT": {"39", "", "00"},
+	"LU": {"352", "", "00"},
+	"NL": {"31", "0", "00"},
+	"NO": {"47", "", "00"},
+	"NZ": {"64", "0", "00"},
+	"PL": {"48", "", "00"},
+	"PT": {"351", "", "00"},
+	"SE": {"46", "0", "00"},
+	"TR": {"90", "0", "00"},
+	"US": {"1", "", "011"},
+}
+
+// A parsed phone number.
+type PhoneNumber struct {
+	CountryCode string // empty if unknown
+	National    string // the national number without trunk prefix
+	Extension   string
+}
+
+// Parse a phone number as it is found in a TEL value,
+// e.g. "+49 (0)170 123-4567", "0170/1234567" or "tel:+1-201-555-0123".
+// Numbers without a country code are taken to be from `region`.
+func ParsePhone(value, region string) (PhoneNumber, error) {
+	var number PhoneNumber
+	s := strings.TrimSpace(value)
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "tel:"), "TEL:")
+	lower := strings.ToLower(s)
+	for _, sep := range []string{";ext=", "ext.", "ext", "x"} {
+		if i := strings.LastIndex(lower, sep); i >= 0 {
+			number.Extension = digits(s[i+len(sep):])
+			s = s[:i]
+			break
+		}
+	}
+	// URI parameters, e.g. ";phone-context="
+	if i := strings.Index(s, ";"); i >= 0 {
+		s = s[:i]
+	}
+
+	for _, r := range s {
+		if !strings.ContainsRune("+0123456789 -./()", r) {
+			return number, fmt.Errorf("Invalid phone number %q", value)
+		}
+	}
+
+	// "+49 (0)170 ..." - the trunk prefix is not dialed
+	// when calling from abroad
+	if i := strings.Index(s, "(0)"); i > 0 {
+		s = s[:i] + s[i+3:]
+	}
+	international := strings.HasPrefix(s, "+")
+	d := digits(s)
+	if len(d) < 3 {
+		return number, fmt.Errorf("Invalid phone number %q", value)
+	}
+
+	rg, knownRegion := phoneRegions[strings.ToUpper(region)]
+	if !international && knownRegion && strings.HasPrefix(d, rg.IDD) {
+		international = true
+		d = d[len(rg.IDD):]
+	} else if !international && !knownRegion && strings.HasPrefix(d, "00") {
+		international = true
+		d = d[2:]
+	}
+
+	if international {
+		number.CountryCode = countryCode(d)
+		number.National = d[len(number.CountryCode):]
+	} else if !knownRegion {
+		return number, fmt.Errorf("Cannot normalize %q without a phone region", value)
+	} else if rg.Trunk != "" && strings.HasPrefix(d, rg.Trunk) {
+		number.CountryCode = rg.Code
+		number.National = d[len(rg.Trunk):]
+	} else if rg.Code == "1" && len(d) == 11 && d[0] == '1' {
+		// NANP, "1 201 555 0123"
+		number.CountryCode = rg.Code
+		number.National = d[1:]
+	} else if rg.Trunk == "" {
+		number.CountryCode = rg.Code
+		number.National = d
+	} else {
+		return number, fmt.Errorf("Cannot normalize %q, area code missing", value)
+	}
+
+	if len(number.CountryCode+number.National) > 15 {
+		return number, fmt.Errorf("Invalid phone number %q, too long", value)
+	}
+	return number, nil
+}
+
+// find the country calling code at the start of an international number
+func countryCode(d string) string {
+	for length := 1; length <= 3 && length < len(d); length++ {
+		for _, rg := range phoneRegions {
+			if rg.Code == d[:length] {
+				return rg.Code
+			}
+		}
+	}
+	return ""
+}
+
+// Format as E.164, e.g. "+491701234567"
+func (p PhoneNumber) E164() string {
+	return "+" + p.CountryCode + p.National
+}
+
+// Format with the country code separated, e.g. "+49 1701234567"
+func (p PhoneNumber) International() string {
+	s := p.E164()
+	if p.CountryCode != "" {
+		s = "+" + p.CountryCode + " " + p.National
+	}
+	if p.Extension != "" {
+		s += " x" + p.Extension
+	}
+	return s
+}
+
+// Format as tel URI (RFC 3966), e.g. "tel:+49-1701234567"
+func (p PhoneNumber) URI() string {
+	s := "tel:" + p.E164()
+	if p.CountryCode != "" {
+		s = "tel:+" + p.CountryCode + "-" + p.National
+	}
+	if p.Extension != "" {
+		s += ";ext=" + p.Extension
+	}
+	return s
+}
+
+// Format a phone number in one of the styles
+// "international" (default), "e164" or "uri".
+func (p PhoneNumber) Format(style string) string {
+	switch style {
+	case "e164":
+		return p.E164()
+	case "uri":
+		return p.URI()
+	default:
+		return p.International()
+	}
+}
+
+// A (proposed) change of a phone number,
+// Err is set if the number could not be normalized.
+type PhoneChange struct {
+	Old string
+	New string
+	Err error
+}
+
+// Rewrite all phone numbers of a card in a consistent format.
+// Returns a list of changed numbers and numbers that could not be parsed.
+func NormalizePhones(card *vdir.Card, region, style string) []PhoneChange {
+	changes := []PhoneChange{}
+	for i, tel := range card.Telephones {
+		number, err := ParsePhone(tel.Value, region)
+		if err != nil {
+			changes = append(changes, PhoneChange{tel.Value, tel.Value, err})
+			continue
+		}
+		formatted := number.Format(style)
+		if formatted != tel.Value {
+			changes = append(changes, PhoneChange{tel.Value, formatted, nil})
+			card.Telephones[i].Value = formatted
+		}
+	}
+	return changes
+}
+
+var errNotPhone = errors.New("Not a phone number")
+
+// Check if a search term looks like a phone number
+// and return it in E.164 format if possible.
+func (q Query) phoneTerm(term string) (string, error) {
+	if !looksLikePhone(term) {
+		return "", errNotPhone
+	}
+	number, err := ParsePhone(term, q.Region)
+	if err != nil {
+		return "", err
+	}
+	return number.E164(), nil
+}
+
+// Check if a search term has only characters used in phone numbers,
+// and at least three digits.
+func looksLikePhone(term string) bool {
+	count := 0
+	for _, r := range term {
+		if r >= '0' && r <= '9' {
+			count++
+		} else if !strings.ContainsRune("+ -./()", r) {
+			return false
+		}
+	}
+	return count >= 3
+}
+
+// Match phone numbers by their digits:
+// a term matches if it is part of the number as written,
+// or if both are the same (or the term is the start of the number)
+// after normalizing to E.164.
+func (q Query) matchPhones(phones []vdir.TypedValue, term string) bool {
+	if q.typedValuesContain(phones, term) {
+		return true
+	}
+	wanted, err := q.phoneTerm(term)
+	if err == errNotPhone {
+		return false
+	}
+	termDigits := digits(term)
+	termNational := nationalDigits(term)
+	for _, tel := range phones {
+		if strings.Contains(digits(tel.Value), termDigits) {
+			return true
+		}
+		number, telErr := ParsePhone(tel.Value, q.Region)
+		if err == nil && telErr == nil {
+			if strings.HasPrefix(number.E164(), wanted) {
+				return true
+			}
+		} else if len(termNational) >= 3 {
+			// without a region, "0170 1234567" cannot be normalized
+			if strings.HasPrefix(nationalDigits(tel.Value), termNational) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// The digits of a phone number without country code and trunk prefix,
+// to compare numbers when they cannot be normalized (see ParsePhone).
+// Leading zeros are taken to be the trunk prefix,
+// so "0170 1234567" and "+49 170 1234567" are both "1701234567".
+func nationalDigits(value string) string {
+	s := strings.TrimSpace(value)
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "tel:"), "TEL:")
+	s = strings.Replace(s, "(0)", "", 1)
+	international := strings.HasPrefix(s, "+")
+	d := digits(s)
+	if !international && strings.HasPrefix(d, "00") {
+		international = true
+		d = d[2:]
+	}
+	if international {
+		d = d[len(countryCode(d)):]
+	}
+	return strings.TrimLeft(d, "0")
+}
+
+// phone numbers of a card in E.164 format, where possible
+func (q Query) e164Phones(card vdir.Card) []string {
+	values := []string{}
+	for _, tel := range card.Telephones {
+		if number, err := ParsePhone(tel.Value, q.Region); err == nil {
+			values = append(values, number.E164())
+		}
+	}
+	return values
+}
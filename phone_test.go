@@ -0,0 +1,81 @@
+package contacts
+
+import (
+	"testing"
+
+	"github.com/xconstruct/vdir"
+)
+
+func TestParsePhone(t *testing.T) {
+	tests := []struct {
+		value  string
+		region string
+		e164   string // empty if an error is expected
+		ext    string
+	}{
+		{"+49 170 1234567", "", "+491701234567", ""},
+		{"+49 (0)170 123-4567", "", "+491701234567", ""},
+		{"0049 170 1234567", "", "+491701234567", ""},
+		{"0170/1234567", "DE", "+491701234567", ""},
+		{"0170 1234567", "", "", ""},
+		{"0170 1234567", "XX", "", ""},
+		{"tel:+1-201-555-0123", "", "+12015550123", ""},
+		{"(201) 555-0123", "US", "+12015550123", ""},
+		{"1 201 555 0123", "US", "+12015550123", ""},
+		{"011 44 20 7946 0958", "US", "+442079460958", ""},
+		{"06 1234 5678", "IT", "+390612345678", ""},
+		{"+49 221 12345 ext. 12", "", "+4922112345", "12"},
+		{"tel:+49-221-12345;ext=7", "", "+4922112345", "7"},
+		{"call me", "DE", "", ""},
+		{"12", "DE", "", ""},
+		{"+49 1234 5678 9012 3456", "", "", ""},
+	}
+	for _, test := range tests {
+		number, err := ParsePhone(test.value, test.region)
+		if test.e164 == "" {
+			if err == nil {
+				t.Errorf("ParsePhone(%q, %q): expected an error, got %v",
+					test.value, test.region, number.E164())
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParsePhone(%q, %q): %v", test.value, test.region, err)
+			continue
+		}
+		if number.E164() != test.e164 || number.Extension != test.ext {
+			t.Errorf("ParsePhone(%q, %q) = %v ext %q, want %v ext %q",
+				test.value, test.region, number.E164(), number.Extension, test.e164, test.ext)
+		}
+	}
+}
+
+func TestMatchPhones(t *testing.T) {
+	phones := []vdir.TypedValue{{Value: "+49 170 1234567"}}
+	tests := []struct {
+		term   string
+		region string
+		want   bool
+	}{
+		{"01701234567", "DE", true},
+		{"01701234567", "", true},
+		{"0170 123", "", true},
+		{"+491701234567", "", true},
+		{"1234567", "", true},
+		{"01709999999", "", false},
+		{"01701234567", "AT", false},
+	}
+	for _, test := range tests {
+		q := Query{Region: test.region}
+		if got := q.matchPhones(phones, test.term); got != test.want {
+			t.Errorf("matchPhones(%q, region %q) = %v, want %v",
+				test.term, test.region, got, test.want)
+		}
+	}
+
+	// a national number on the card, an international search term
+	q := Query{}
+	if !q.matchPhones([]vdir.TypedValue{{Value: "0170 1234567"}}, "+49 170 1234567") {
+		t.Errorf("Expected +49 170 1234567 to match 0170 1234567 without region")
+	}
+}
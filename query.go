@@ -0,0 +1,458 @@
+package contacts
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/xconstruct/vdir"
+)
+
+// Query Language
+//
+// A query consists of terms combined with AND, OR and NOT
+// (upper case) and grouped with parentheses. Adjacent terms are
+// combined with AND:
+//
+//	email:@acme.com AND category:work AND NOT org:"Old Corp"
+//	(tel:+49* OR tel:0049*) bday:exists
+//
+// A plain term like `john` matches names, nick names,
+// mail addresses and phone numbers.
+// A qualified term `field:value` matches only the given field,
+// with an unknown field name (e.g. `http://example.com`) it is a plain term.
+// The value `exists` matches if the field is set at all,
+// `*` and `?` are wildcards for any number of / a single character.
+// Quote values that contain spaces or should be taken literally.
+
+// Parse the search term of a query.
+// The query matches cards that match the expression `term` AND one of the
+// `categories` (if given).
+func ParseQuery(term string, categories []string) (Query, error) {
+	query := Query{Term: term, Categories: categories}
+	p := &parser{input: term}
+	err := p.tokenize()
+	if err != nil {
+		return query, err
+	}
+	if len(p.tokens) == 1 {
+		// empty query, matches everything
+		return query, nil
+	}
+	if looksLikePhone(term) {
+		// e.g. "(030) 1234", not a group and another term
+		query.expr = termExpr{strings.TrimSpace(term)}
+		return query, nil
+	}
+
+	query.expr, err = p.parseOr()
+	if err != nil {
+		return query, err
+	}
+	if p.peek().kind != tokEOF {
+		return query, p.errorf(p.peek().pos, "unexpected %v", p.peek())
+	}
+	return query, nil
+}
+
+// QueryError is a syntax error in a query.
+// Pos is the (zero based) position in the query string.
+type QueryError struct {
+	Query string
+	Pos   int
+	Msg   string
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("Invalid query: %s\n  %s\n  %s^",
+		e.Msg, e.Query, strings.Repeat(" ", len([]rune(e.Query[:e.Pos]))))
+}
+
+// AST -------------------------------------------------------------------------
+
+// a node in the parsed query
+type expr interface {
+	eval(q Query, card vdir.Card) bool
+}
+
+type andExpr struct {
+	left, right expr
+}
+
+func (e andExpr) eval(q Query, card vdir.Card) bool {
+	return e.left.eval(q, card) && e.right.eval(q, card)
+}
+
+type orExpr struct {
+	left, right expr
+}
+
+func (e orExpr) eval(q Query, card vdir.Card) bool {
+	return e.left.eval(q, card) || e.right.eval(q, card)
+}
+
+type notExpr struct {
+	x expr
+}
+
+func (e notExpr) eval(q Query, card vdir.Card) bool {
+	return !e.x.eval(q, card)
+}
+
+// a plain search term
+type termExpr struct {
+	term string
+}
+
+func (e termExpr) eval(q Query, card vdir.Card) bool {
+	return q.matchTerm(card, e.term)
+}
+
+// a term that is qualified with a field name
+type fieldExpr struct {
+	field  string
+	value  string
+	quoted bool
+}
+
+func (e fieldExpr) eval(q Query, card vdir.Card) bool {
+	values := queryFields[e.field](card)
+	if e.value == "exists" && !e.quoted {
+		for _, v := range values {
+			if strings.TrimSpace(v) != "" {
+				return true
+			}
+		}
+		return false
+	}
+
+	var glob *regexp.Regexp
+	if !e.quoted && strings.ContainsAny(e.value, "*?") {
+		glob = globRegexp(q.normalize(e.value))
+		if e.field == "tel" {
+			values = append(values, q.e164Phones(card)...)
+		}
+	} else if e.field == "tel" {
+		return q.matchPhones(card.Telephones, e.value)
+	}
+	for _, v := range values {
+		if glob != nil {
+			if glob.MatchString(q.normalize(v)) {
+				return true
+			}
+		} else if e.field == "category" {
+			if q.equal(v, e.value) {
+				return true
+			}
+		} else if q.contains(v, e.value) {
+			return true
+		}
+	}
+	return false
+}
+
+// translate a pattern with wildcards into a case insensitive regexp
+func globRegexp(pattern string) *regexp.Regexp {
+	quoted := regexp.QuoteMeta(pattern)
+	quoted = strings.Replace(quoted, `\*`, ".*", -1)
+	quoted = strings.Replace(quoted, `\?`, ".", -1)
+	return regexp.MustCompile("(?i)^" + quoted + "$")
+}
+
+// Fields that can be used in a query
+// and the card values they refer to.
+var queryFields = map[string]func(vdir.Card) []string{
+	"name": func(c vdir.Card) []string {
+		values := []string{c.FormattedName}
+		values = append(values, c.Name.GivenName...)
+		values = append(values, c.Name.AdditionalNames...)
+		return append(values, c.Name.FamilyName...)
+	},
+	"first":    func(c vdir.Card) []string { return c.Name.GivenName },
+	"last":     func(c vdir.Card) []string { return c.Name.FamilyName },
+	"nick":     func(c vdir.Card) []string { return c.NickName },
+	"email":    func(c vdir.Card) []string { return typedValues(c.Email) },
+	"tel":      func(c vdir.Card) []string { return typedValues(c.Telephones) },
+	"url":      func(c vdir.Card) []string { return typedValues(c.Url) },
+	"org":      func(c vdir.Card) []string { return []string{c.Org} },
+	"title":    func(c vdir.Card) []string { return []string{c.Title} },
+	"role":     func(c vdir.Card) []string { return []string{c.Role} },
+	"note":     func(c vdir.Card) []string { return []string{c.Note} },
+	"bday":     func(c vdir.Card) []string { return []string{c.Birthday} },
+	"uid":      func(c vdir.Card) []string { return []string{c.Uid} },
+	"category": func(c vdir.Card) []string { return c.Categories },
+	"adr": func(c vdir.Card) []string {
+		values := []string{}
+		for _, a := range c.Addresses {
+			values = append(values, a.Street, a.Locality, a.Region,
+				a.PostalCode, a.CountryName)
+		}
+		return values
+	},
+}
+
+// alternative names for query fields
+var queryFieldAliases = map[string]string{
+	"given":      "first",
+	"family":     "last",
+	"nickname":   "nick",
+	"mail":       "email",
+	"phone":      "tel",
+	"birthday":   "bday",
+	"categories": "category",
+	"address":    "adr",
+}
+
+func typedValues(tvalues []vdir.TypedValue) []string {
+	values := []string{}
+	for _, tv := range tvalues {
+		values = append(values, tv.Value)
+	}
+	return values
+}
+
+// Parser ----------------------------------------------------------------------
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokWord
+	tokField
+	tokAnd
+	tokOr
+	tokNot
+	tokLParen
+	tokRParen
+)
+
+type token struct {
+	kind   tokenKind
+	pos    int
+	field  string
+	value  string
+	quoted bool
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of query"
+	case tokAnd:
+		return "AND"
+	case tokOr:
+		return "OR"
+	case tokNot:
+		return "NOT"
+	case tokLParen:
+		return "'('"
+	case tokRParen:
+		return "')'"
+	case tokField:
+		return fmt.Sprintf("%q", t.field+":"+t.value)
+	}
+	return fmt.Sprintf("%q", t.value)
+}
+
+type parser struct {
+	input  string
+	tokens []token
+	next   int
+}
+
+func (p *parser) errorf(pos int, format string, args ...interface{}) error {
+	return &QueryError{p.input, pos, fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) tokenize() error {
+	s := p.input
+	i := 0
+	for i < len(s) {
+		switch c := s[i]; {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '(':
+			p.tokens = append(p.tokens, token{kind: tokLParen, pos: i})
+			i++
+		case c == ')':
+			p.tokens = append(p.tokens, token{kind: tokRParen, pos: i})
+			i++
+		case c == '"':
+			value, end, err := p.quoted(i)
+			if err != nil {
+				return err
+			}
+			p.tokens = append(p.tokens, token{kind: tokWord, pos: i, value: value, quoted: true})
+			i = end
+		default:
+			tok, end, err := p.word(i)
+			if err != nil {
+				return err
+			}
+			p.tokens = append(p.tokens, tok)
+			i = end
+		}
+	}
+	p.tokens = append(p.tokens, token{kind: tokEOF, pos: len(s)})
+	return nil
+}
+
+// read a quoted string starting at `start`,
+// return the unquoted value and the position after the closing quote.
+func (p *parser) quoted(start int) (string, int, error) {
+	var value []byte
+	for i := start + 1; i < len(p.input); i++ {
+		c := p.input[i]
+		if c == '\\' && i+1 < len(p.input) {
+			i++
+			value = append(value, p.input[i])
+		} else if c == '"' {
+			return string(value), i + 1, nil
+		} else {
+			value = append(value, c)
+		}
+	}
+	return "", 0, p.errorf(start, "unterminated quote")
+}
+
+// read a word, an operator or a `field:value` term.
+// Parentheses within a word are part of it, e.g. "+49(0)170".
+// A word with an unknown field name like "http://example.com"
+// is a plain term.
+func (p *parser) word(start int) (token, int, error) {
+	end := start
+	depth := 0
+	for ; end < len(p.input); end++ {
+		c := p.input[end]
+		if c == '(' {
+			depth++
+		} else if c == ')' && depth > 0 {
+			depth--
+		} else if strings.ContainsRune(" \t)\"", rune(c)) {
+			break
+		}
+	}
+	text := p.input[start:end]
+	switch text {
+	case "AND":
+		return token{kind: tokAnd, pos: start}, end, nil
+	case "OR":
+		return token{kind: tokOr, pos: start}, end, nil
+	case "NOT":
+		return token{kind: tokNot, pos: start}, end, nil
+	}
+
+	colon := strings.Index(text, ":")
+	if colon < 0 {
+		return token{kind: tokWord, pos: start, value: text}, end, nil
+	}
+
+	field := strings.ToLower(text[:colon])
+	if alias, ok := queryFieldAliases[field]; ok {
+		field = alias
+	}
+	if _, ok := queryFields[field]; !ok {
+		return token{kind: tokWord, pos: start, value: text}, end, nil
+	}
+
+	tok := token{kind: tokField, pos: start, field: field, value: text[colon+1:]}
+	if tok.value == "" && end < len(p.input) && p.input[end] == '"' {
+		value, quotedEnd, err := p.quoted(end)
+		if err != nil {
+			return tok, 0, err
+		}
+		tok.value = value
+		tok.quoted = true
+		end = quotedEnd
+	}
+	if tok.value == "" && !tok.quoted {
+		return tok, 0, p.errorf(start+colon+1, "missing value for field %q", field)
+	}
+	return tok, end, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.next]
+}
+
+func (p *parser) consume() token {
+	tok := p.tokens[p.next]
+	if tok.kind != tokEOF {
+		p.next++
+	}
+	return tok
+}
+
+// or = and { "OR" and }
+func (p *parser) parseOr() (expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokOr {
+		p.consume()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orExpr{left, right}
+	}
+	return left, nil
+}
+
+// and = not { ["AND"] not }
+func (p *parser) parseAnd() (expr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch p.peek().kind {
+		case tokAnd:
+			p.consume()
+		case tokWord, tokField, tokNot, tokLParen:
+			// implicit AND
+		default:
+			return left, nil
+		}
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = andExpr{left, right}
+	}
+}
+
+// not = "NOT" not | primary
+func (p *parser) parseNot() (expr, error) {
+	if p.peek().kind == tokNot {
+		p.consume()
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{x}, nil
+	}
+	return p.parsePrimary()
+}
+
+// primary = "(" or ")" | word | field
+func (p *parser) parsePrimary() (expr, error) {
+	tok := p.consume()
+	switch tok.kind {
+	case tokLParen:
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.consume(); closing.kind != tokRParen {
+			return nil, p.errorf(closing.pos, "expected ')', found %v", closing)
+		}
+		return x, nil
+	case tokWord:
+		return termExpr{tok.value}, nil
+	case tokField:
+		return fieldExpr{tok.field, tok.value, tok.quoted}, nil
+	}
+	return nil, p.errorf(tok.pos, "expected a search term, found %v", tok)
+}
@@ -0,0 +1,81 @@
+package contacts
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/xconstruct/vdir"
+)
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		term string
+		expr expr
+	}{
+		{"john", termExpr{"john"}},
+		{"john doe", andExpr{termExpr{"john"}, termExpr{"doe"}}},
+		{"org:acme", fieldExpr{"org", "acme", false}},
+		{"mail:@acme.com", fieldExpr{"email", "@acme.com", false}},
+		// unknown field names are plain terms
+		{"http://example.com", termExpr{"http://example.com"}},
+		{"a:b", termExpr{"a:b"}},
+		{"a:b OR org:x", orExpr{termExpr{"a:b"}, fieldExpr{"org", "x", false}}},
+		// phone numbers with parentheses
+		{"(030) 1234", termExpr{"(030) 1234"}},
+		{"+49 (0)30 1234", termExpr{"+49 (0)30 1234"}},
+		{"tel:+49(0)30*", fieldExpr{"tel", "+49(0)30*", false}},
+		{"john (030)", andExpr{termExpr{"john"}, termExpr{"030"}}},
+		// grouping
+		{"(a OR b) c", andExpr{orExpr{termExpr{"a"}, termExpr{"b"}}, termExpr{"c"}}},
+		{"c AND (a OR b)", andExpr{termExpr{"c"}, orExpr{termExpr{"a"}, termExpr{"b"}}}},
+		{"(tel:+49* OR tel:0049*)", orExpr{fieldExpr{"tel", "+49*", false}, fieldExpr{"tel", "0049*", false}}},
+		{"NOT (a)", notExpr{termExpr{"a"}}},
+	}
+	for _, test := range tests {
+		q, err := ParseQuery(test.term, nil)
+		if err != nil {
+			t.Errorf("%q: %v", test.term, err)
+			continue
+		}
+		if !reflect.DeepEqual(q.expr, test.expr) {
+			t.Errorf("%q: got %#v, want %#v", test.term, q.expr, test.expr)
+		}
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	for _, term := range []string{"(a OR b", "a OR", "org:", `"open`, "a )"} {
+		if _, err := ParseQuery(term, nil); err == nil {
+			t.Errorf("%q: expected an error", term)
+		}
+	}
+}
+
+func TestQueryMatches(t *testing.T) {
+	card := vdir.Card{
+		FormattedName: "John Doe",
+		Email:         []vdir.TypedValue{{Value: "john@example.com"}},
+		Telephones:    []vdir.TypedValue{{Value: "+49 30 1234567"}},
+	}
+	tests := []struct {
+		term  string
+		match bool
+	}{
+		{"(030) 1234567", true},
+		{"030/1234567", true},
+		{"(040) 1234567", false},
+		{"john (doe OR smith)", true},
+		{"http://example.com", false},
+		{"john@example.com", true},
+	}
+	for _, test := range tests {
+		q, err := ParseQuery(test.term, nil)
+		if err != nil {
+			t.Errorf("%q: %v", test.term, err)
+			continue
+		}
+		if q.Matches(card) != test.match {
+			t.Errorf("%q: got %v, want %v", test.term, !test.match, test.match)
+		}
+	}
+}
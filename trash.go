@@ -0,0 +1,219 @@
+package contacts
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xconstruct/vdir"
+)
+
+const trashInfoExt = ".trashinfo"
+
+// The Trash holds deleted cards so that they can be restored.
+// Each card is kept together with a `.trashinfo` file that records
+// the original path and the deletion time
+// (similar to the freedesktop.org trash spec).
+type Trash struct {
+	Dirname string
+}
+
+// A card that was moved to the trash.
+// Err is set if the card file cannot be read,
+// such cards can still be restored or purged.
+type TrashedCard struct {
+	Card         vdir.Card
+	Path         string
+	OriginalPath string
+	DeletionDate time.Time
+	Err          error
+}
+
+func NewTrash(dirname string) Trash {
+	return Trash{dirname}
+}
+
+// Move the file at `path` into the trash.
+func (t Trash) Put(path string) error {
+	err := os.MkdirAll(t.Dirname, 0700)
+	if err != nil {
+		return err
+	}
+	abspath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	dst := filepath.Join(t.Dirname, t.freeName(filepath.Base(path)))
+	infoPath := dst + trashInfoExt
+	// write the info first, a card in the trash should always have one
+	err = writeTrashInfo(infoPath, abspath, time.Now())
+	if err != nil {
+		return err
+	}
+	err = os.Rename(path, dst)
+	if err != nil {
+		os.Remove(infoPath)
+		return err
+	}
+	log.Printf("Moved %s to %s", path, dst)
+	return nil
+}
+
+// find a filename that is not yet used in the trash
+func (t Trash) freeName(name string) string {
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	candidate := name
+	for i := 1; ; i++ {
+		_, err := os.Lstat(filepath.Join(t.Dirname, candidate))
+		if os.IsNotExist(err) {
+			return candidate
+		}
+		candidate = stem + "-" + strconv.Itoa(i) + ext
+	}
+}
+
+// List the cards in the trash, most recently deleted first.
+func (t Trash) List() ([]TrashedCard, error) {
+	items := []TrashedCard{}
+	files, err := ioutil.ReadDir(t.Dirname)
+	if os.IsNotExist(err) {
+		return items, nil
+	} else if err != nil {
+		return items, err
+	}
+
+	for _, file := range files {
+		if !file.Mode().IsRegular() || filepath.Ext(file.Name()) != ".vcf" {
+			continue
+		}
+		path := filepath.Join(t.Dirname, file.Name())
+		item, err := readTrashInfo(path + trashInfoExt)
+		if err != nil {
+			log.Printf("Skip %s: %v", path, err)
+			continue
+		}
+		card, err := loadCard(path)
+		if err != nil {
+			log.Printf("Cannot read %s: %v", path, err)
+			item.Err = err
+		} else {
+			item.Card = *card
+		}
+		item.Path = path
+		items = append(items, item)
+	}
+	sort.Sort(byDeletionDate(items))
+	return items, nil
+}
+
+// Move a trashed card back to its original location.
+// Fails if a file exists at the original location.
+func (t Trash) Restore(item TrashedCard) error {
+	_, err := os.Lstat(item.OriginalPath)
+	if err == nil {
+		return fmt.Errorf("Cannot restore, %s exists", item.OriginalPath)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	err = os.Rename(item.Path, item.OriginalPath)
+	if err != nil {
+		return err
+	}
+	return os.Remove(item.Path + trashInfoExt)
+}
+
+// Permanently delete cards that were trashed more than `age` ago.
+// Returns the number of deleted cards.
+func (t Trash) Purge(age time.Duration) (int, error) {
+	count := 0
+	items, err := t.List()
+	if err != nil {
+		return count, err
+	}
+
+	cutoff := time.Now().Add(-age)
+	for _, item := range items {
+		if item.DeletionDate.After(cutoff) {
+			continue
+		}
+		err = os.Remove(item.Path)
+		if err != nil {
+			return count, err
+		}
+		err = os.Remove(item.Path + trashInfoExt)
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// The info file looks like this:
+//
+//	[Trash Info]
+//	Path=/home/user/contacts/1234.vcf
+//	DeletionDate=2016-08-01T12:00:00Z
+func writeTrashInfo(path, original string, deleted time.Time) error {
+	info := "[Trash Info]\n" +
+		"Path=" + original + "\n" +
+		"DeletionDate=" + deleted.UTC().Format(time.RFC3339) + "\n"
+	return ioutil.WriteFile(path, []byte(info), 0600)
+}
+
+func readTrashInfo(path string) (TrashedCard, error) {
+	var item TrashedCard
+	file, err := os.Open(path)
+	if err != nil {
+		return item, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "Path=") {
+			item.OriginalPath = strings.TrimPrefix(line, "Path=")
+		} else if strings.HasPrefix(line, "DeletionDate=") {
+			value := strings.TrimPrefix(line, "DeletionDate=")
+			item.DeletionDate, err = time.Parse(time.RFC3339, value)
+			if err != nil {
+				return item, err
+			}
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return item, err
+	}
+
+	if item.OriginalPath == "" {
+		return item, errors.New("Missing original path in trash info")
+	}
+	return item, nil
+}
+
+// Sort Helper
+type byDeletionDate []TrashedCard
+
+func (a byDeletionDate) Len() int {
+	return len(a)
+}
+
+func (a byDeletionDate) Swap(front, back int) {
+	a[front], a[back] = a[back], a[front]
+}
+
+func (a byDeletionDate) Less(i, j int) bool {
+	return a[i].DeletionDate.After(a[j].DeletionDate)
+}
@@ -3,12 +3,16 @@ package contacts
 // go:generate go-bindata -pkg $GOPACKAGE -o assets.go tpl/
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"os"
+	"path/filepath"
 	"sort"
 	"strings"
 	"text/template"
+	"time"
 
 	"github.com/gosuri/uitable"
 	"github.com/xconstruct/vdir"
@@ -42,27 +46,86 @@ func join(list []string) string {
 	return strings.Join(list, ", ")
 }
 
+// Options for rendering a list of cards.
+// Columns and Expand are used for CSV, see writeCSV.
+// Books adds a column with the name of the address book
+// to the table and the default CSV columns.
+type ListOptions struct {
+	Format  string
+	Columns []string
+	Expand  bool
+	Books   bool
+}
+
 // Render a list of cards
-func ShowList(cards []vdir.Card, format string) {
-	sort.Sort(ByName(cards))
-	switch format {
+func ShowList(matches []Match, opts ListOptions) error {
+	sort.SliceStable(matches, func(i, j int) bool {
+		return FormatName(matches[i].Card) < FormatName(matches[j].Card)
+	})
+	cards := []vdir.Card{}
+	for _, match := range matches {
+		cards = append(cards, match.Card)
+	}
+	var err error
+	switch opts.Format {
+	case "csv":
+		columns := opts.Columns
+		if len(columns) == 0 {
+			columns = DefaultCSVColumns
+			if opts.Books {
+				columns = append(columns[:len(columns):len(columns)], "book")
+			}
+		}
+		err = writeCSV(os.Stdout, matches, columns, opts.Expand)
 	case "sup":
 		renderSupContacts(cards)
+	case "json":
+		err = writeJSON(os.Stdout, matches)
+	case "ndjson":
+		err = writeNDJSON(os.Stdout, matches)
 	default:
-		renderTable(cards)
+		renderTable(matches, opts.Books)
+	}
+	return err
+}
+
+// Render details for a single card.
+// The "default" format uses the show template, other formats are
+// "json" and "ndjson".
+func ShowCard(match Match, format string) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(jsonMatch(match))
+	case "ndjson":
+		return writeNDJSON(os.Stdout, []Match{match})
 	}
+	return ShowDetails(match.Card)
 }
 
 // render card data into a table for display
-func renderTable(cards []vdir.Card) {
+func renderTable(matches []Match, books bool) {
 	table := uitable.New()
 	table.Separator = "  "
-	table.AddRow("NAME", "MAIL", "PHONE")
+	if books {
+		table.AddRow("NAME", "MAIL", "PHONE", "BOOK")
+	} else {
+		table.AddRow("NAME", "MAIL", "PHONE")
+	}
 
-	for _, card := range cards {
-		table.AddRow(FormatName(card),
-			PrimaryMail(card),
-			PrimaryPhone(card))
+	for _, match := range matches {
+		card := match.Card
+		if books {
+			table.AddRow(FormatName(card),
+				PrimaryMail(card),
+				PrimaryPhone(card),
+				match.Book.Name)
+		} else {
+			table.AddRow(FormatName(card),
+				PrimaryMail(card),
+				PrimaryPhone(card))
+		}
 	}
 
 	fmt.Println(table)
@@ -87,3 +150,51 @@ func renderSupContacts(cards []vdir.Card) {
 			FormatName(card), PrimaryMail(card))
 	}
 }
+
+// Render the contents of the trash
+func ShowTrash(items []TrashedCard) {
+	table := uitable.New()
+	table.Separator = "  "
+	table.AddRow("NAME", "DELETED", "PATH")
+
+	for _, item := range items {
+		name := FormatName(item.Card)
+		if item.Err != nil {
+			name = "(broken: " + filepath.Base(item.Path) + ")"
+		}
+		table.AddRow(name,
+			item.DeletionDate.Local().Format(time.RFC822),
+			item.OriginalPath)
+	}
+
+	fmt.Println(table)
+}
+
+// Render upcoming birthdays and anniversaries
+func ShowUpcoming(occasions []Occasion, now time.Time) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	table := uitable.New()
+	table.Separator = "  "
+	table.AddRow("DATE", "WHEN", "NAME", "OCCASION")
+	for _, o := range occasions {
+		if o.Undated() {
+			table.AddRow("-", "", FormatName(o.Card), fmt.Sprintf("%v: %v", o.Kind, o.Text))
+			continue
+		}
+		days := int(o.Next.Sub(today).Hours()/24 + 0.5)
+		when := fmt.Sprintf("in %d days", days)
+		if days == 0 {
+			when = "today"
+		} else if days == 1 {
+			when = "tomorrow"
+		}
+		occasion := o.Kind
+		if o.Years > 0 && o.Kind == "birthday" {
+			occasion = fmt.Sprintf("birthday, turns %d", o.Years)
+		} else if o.Years > 0 {
+			occasion = fmt.Sprintf("anniversary, %d years", o.Years)
+		}
+		table.AddRow(o.Next.Format("Mon 02 Jan"), when, FormatName(o.Card), occasion)
+	}
+	fmt.Println(table)
+}
@@ -0,0 +1,85 @@
+package contacts
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xconstruct/vdir"
+)
+
+// the compared fields of an Occasion
+type upcoming struct {
+	name  string
+	kind  string
+	next  time.Time
+	years int
+	text  string
+}
+
+func TestUpcoming(t *testing.T) {
+	card := func(name, bday, anniversary string) vdir.Card {
+		return vdir.Card{FormattedName: name, Birthday: bday, Anniversary: anniversary}
+	}
+	day := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		now   time.Time
+		cards []vdir.Card
+		want  []upcoming
+	}{
+		// Feb 29 is on Feb 28 in years that are no leap years
+		{
+			time.Date(2023, time.February, 20, 10, 0, 0, 0, time.UTC),
+			[]vdir.Card{card("Leap", "", "20080229")},
+			[]upcoming{{"Leap", "anniversary", day(2023, time.February, 28), 15, ""}},
+		},
+		{
+			time.Date(2024, time.February, 20, 10, 0, 0, 0, time.UTC),
+			[]vdir.Card{card("Leap", "", "20080229")},
+			[]upcoming{{"Leap", "anniversary", day(2024, time.February, 29), 16, ""}},
+		},
+		// dates after the end of the year
+		{
+			time.Date(2023, time.December, 20, 10, 0, 0, 0, time.UTC),
+			[]vdir.Card{
+				card("March", "--0301", ""),
+				card("January", "19900105", ""),
+				card("New Year", "--0101", ""),
+				card("Today", "--1220", ""),
+			},
+			[]upcoming{
+				{"Today", "birthday", day(2023, time.December, 20), 0, ""},
+				{"New Year", "birthday", day(2024, time.January, 1), 0, ""},
+				{"January", "birthday", day(2024, time.January, 5), 34, ""},
+			},
+		},
+		// text values come last, partial dates are skipped
+		{
+			time.Date(2023, time.June, 1, 10, 0, 0, 0, time.UTC),
+			[]vdir.Card{
+				card("Text", "circa 1800", ""),
+				card("Partial", "1985-04", ""),
+				card("June", "1985-06-02", ""),
+			},
+			[]upcoming{
+				{"June", "birthday", day(2023, time.June, 2), 38, ""},
+				{"Text", "birthday", time.Time{}, 0, "circa 1800"},
+			},
+		},
+	}
+	for _, test := range tests {
+		found := Upcoming(test.cards, 30, test.now)
+		if len(found) != len(test.want) {
+			t.Errorf("%v: got %d occasions, want %d", test.now, len(found), len(test.want))
+			continue
+		}
+		for i, want := range test.want {
+			o := found[i]
+			got := upcoming{o.Card.FormattedName, o.Kind, o.Next, o.Years, o.Text}
+			if got != want {
+				t.Errorf("%v: occasion %d is %v, want %v", test.now, i, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package contacts
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Value types for properties that are not "text" by default (RFC 6350).
+var defaultValueTypes = map[string]string{
+	"SOURCE":      "uri",
+	"PHOTO":       "uri",
+	"IMPP":        "uri",
+	"GEO":         "uri",
+	"LOGO":        "uri",
+	"MEMBER":      "uri",
+	"RELATED":     "uri",
+	"SOUND":       "uri",
+	"URL":         "uri",
+	"KEY":         "uri",
+	"FBURL":       "uri",
+	"CALADRURI":   "uri",
+	"CALURI":      "uri",
+	"BDAY":        "date-and-or-time",
+	"ANNIVERSARY": "date-and-or-time",
+	"REV":         "timestamp",
+	"LANG":        "language-tag",
+}
+
+// Properties with structured values, components are separated by ";".
+var structuredProperties = map[string]bool{
+	"N":            true,
+	"ADR":          true,
+	"ORG":          true,
+	"GENDER":       true,
+	"CLIENTPIDMAP": true,
+}
+
+// Properties that hold a list of values, separated by ",".
+var listProperties = map[string]bool{
+	"NICKNAME":   true,
+	"CATEGORIES": true,
+}
+
+// The value type of a property, from the VALUE parameter
+// or the default for the property.
+func valueType(p property) string {
+	if values := p.Param("VALUE"); len(values) > 0 {
+		return strings.ToLower(values[0])
+	}
+	return defaultValueType(p.Name)
+}
+
+func defaultValueType(name string) string {
+	if t, ok := defaultValueTypes[name]; ok {
+		return t
+	}
+	return "text"
+}
+
+func isDateType(valueType string) bool {
+	switch valueType {
+	case "date", "time", "date-time", "date-and-or-time", "timestamp":
+		return true
+	}
+	return false
+}
+
+var (
+	basicDateRegex  = regexp.MustCompile(`^(\d{4})(\d{2})(\d{2})$`)
+	basicMonthDay   = regexp.MustCompile(`^--(\d{2})(\d{2})$`)
+	basicTimeRegex  = regexp.MustCompile(`^(\d{2})(\d{2})(\d{2})?(.*)$`)
+	basicZoneRegex  = regexp.MustCompile(`^([+-]\d{2})(\d{2})$`)
+	extDateRegex    = regexp.MustCompile(`^(\d{4})-(\d{2})-(\d{2})$`)
+	extMonthDay     = regexp.MustCompile(`^--(\d{2})-(\d{2})$`)
+	extZoneRegex    = regexp.MustCompile(`^([+-]\d{2}):(\d{2})$`)
+	extTimeSplitter = regexp.MustCompile(`^([\d:]*)(.*)$`)
+)
+
+// Convert a date or time from the basic format used in vCard 4.0 and xCard
+// (e.g. 19850412T101500Z) to the extended format used in jCard and vCard 3.0
+// (e.g. 1985-04-12T10:15:00Z). Values in other formats are kept.
+func extendedDateTime(s string) string {
+	date, time, hasTime := cutTime(s)
+	date = basicDateRegex.ReplaceAllString(date, "$1-$2-$3")
+	date = basicMonthDay.ReplaceAllString(date, "--$1-$2")
+	if !hasTime {
+		return date
+	}
+	if groups := basicTimeRegex.FindStringSubmatch(time); groups != nil {
+		time = groups[1] + ":" + groups[2]
+		if groups[3] != "" {
+			time += ":" + groups[3]
+		}
+		time += basicZoneRegex.ReplaceAllString(groups[4], "$1:$2")
+	}
+	return date + "T" + time
+}
+
+// Convert a date or time from the extended format to the basic format,
+// see extendedDateTime.
+func basicDateTime(s string) string {
+	date, time, hasTime := cutTime(s)
+	date = extDateRegex.ReplaceAllString(date, "$1$2$3")
+	date = extMonthDay.ReplaceAllString(date, "--$1$2")
+	if !hasTime {
+		return date
+	}
+	groups := extTimeSplitter.FindStringSubmatch(time)
+	time = strings.Replace(groups[1], ":", "", -1) +
+		extZoneRegex.ReplaceAllString(groups[2], "$1$2")
+	return date + "T" + time
+}
+
+func cutTime(s string) (string, string, bool) {
+	if i := strings.Index(s, "T"); i >= 0 {
+		return s[:i], s[i+1:], true
+	}
+	return s, "", false
+}
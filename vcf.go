@@ -0,0 +1,157 @@
+package contacts
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/xconstruct/vdir"
+)
+
+// Read a stream of vCards, e.g. a .vcf file exported from a phone.
+// The stream is split at BEGIN:VCARD and END:VCARD and each card is parsed
+// on its own, so that a broken card does not affect the others.
+// Cards that cannot be parsed are reported in the list of errors.
+func readVCF(reader io.Reader) ([]ImportedCard, []error, error) {
+	cards := []ImportedCard{}
+	failed := []error{}
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	var buf bytes.Buffer
+	depth := 0
+	lineno := 0
+	start := 0
+	count := 0
+	for scanner.Scan() {
+		lineno++
+		line := scanner.Text()
+		if lineno == 1 {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+		trimmed := strings.ToUpper(strings.TrimSpace(line))
+
+		if depth == 0 {
+			if trimmed == "" {
+				continue
+			} else if trimmed != "BEGIN:VCARD" {
+				log.Printf("Ignore line %d outside of BEGIN:VCARD and END:VCARD", lineno)
+				continue
+			}
+			start = lineno
+			buf.Reset()
+		}
+		buf.WriteString(line + "\n")
+
+		switch trimmed {
+		case "BEGIN:VCARD":
+			depth++
+		case "END:VCARD":
+			depth--
+		}
+		if depth > 0 {
+			continue
+		}
+
+		count++
+		var card vdir.Card
+		err := unmarshalCard(buf.Bytes(), &card)
+		if err != nil {
+			failed = append(failed, vcfError(count, start, err))
+			continue
+		}
+		// checked by unmarshalCard
+		props, _ := parseProperties(buf.Bytes())
+		cards = append(cards, ImportedCard{card, props[1 : len(props)-1]})
+	}
+	if err := scanner.Err(); err != nil {
+		return cards, failed, err
+	}
+	if depth > 0 {
+		failed = append(failed, &SyntaxError{start, "missing END:VCARD"})
+	}
+	return cards, failed, nil
+}
+
+// an error for the n-th card, with the line number in the stream
+func vcfError(n, start int, err error) error {
+	if syntaxErr, ok := err.(*SyntaxError); ok {
+		return fmt.Errorf("vCard %d: %v", n, &SyntaxError{start + syntaxErr.Line - 1, syntaxErr.Msg})
+	}
+	return fmt.Errorf("vCard %d (line %d): %v", n, start, err)
+}
+
+// Write cards as a stream of vCards in the version given by `opts`.
+func writeVCF(writer io.Writer, matches []Match, opts ExportOptions) error {
+	version := opts.Version
+	if version == "" {
+		version = "4.0"
+	}
+	for _, match := range matches {
+		props, err := exportProperties(match, version, opts)
+		if err != nil {
+			return err
+		}
+		err = writeCard(writer, props)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Marshal a card in the given vCard version.
+func marshalCard(card vdir.Card, version string) ([]byte, error) {
+	props, err := cardProperties(card)
+	if err != nil {
+		return nil, err
+	}
+	props, err = convertProperties(props, version)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	err = writeCard(&buf, props)
+	return buf.Bytes(), err
+}
+
+// write properties enclosed in BEGIN:VCARD and END:VCARD
+func writeCard(writer io.Writer, props []property) error {
+	all := append([]property{{Name: "BEGIN", Value: "VCARD"}}, props...)
+	all = append(all, property{Name: "END", Value: "VCARD"})
+	return writeProperties(writer, all)
+}
+
+// properties of a card for export in the given version,
+// without the ones to strip.
+// They are read from the card's file, so that parameters and properties
+// that vdir does not know are kept.
+//
+// Properties are stripped before and after the conversion, so they are
+// left out by their name in the file and by their name in `version`
+// (e.g. GENDER becomes X-GENDER in vCard 3.0).
+func exportProperties(match Match, version string, opts ExportOptions) ([]property, error) {
+	var props []property
+	var err error
+	if match.Book != nil {
+		props, err = match.Book.fileProperties(match.Card)
+		if err != nil {
+			log.Printf("Export %v without its file: %v", FormatName(match.Card), err)
+		}
+	}
+	if match.Book == nil || err != nil {
+		props, err = cardProperties(match.Card)
+		if err != nil {
+			return nil, err
+		}
+	}
+	props = stripProperties(props, opts.Strip)
+	props, err = convertProperties(props, version)
+	if err != nil {
+		return nil, err
+	}
+	return stripProperties(props, opts.Strip), nil
+}
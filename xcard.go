@@ -0,0 +1,323 @@
+package contacts
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// xCard (RFC 6351) is the XML representation of vCard:
+//
+//	<vcards xmlns="urn:ietf:params:xml:ns:vcard-4.0">
+//	  <vcard>
+//	    <fn><text>John Doe</text></fn>
+//	    <email>
+//	      <parameters><type><text>work</text></type></parameters>
+//	      <text>john.doe@example.com</text>
+//	    </email>
+//	  </vcard>
+//	</vcards>
+const xcardNamespace = "urn:ietf:params:xml:ns:vcard-4.0"
+
+// Element names for the components of structured properties.
+// ORG has no named components, each is a <text> element.
+var xcardComponents = map[string][]string{
+	"N":            {"surname", "given", "additional", "prefix", "suffix"},
+	"ADR":          {"pobox", "ext", "street", "locality", "region", "code", "country"},
+	"GENDER":       {"sex", "identity"},
+	"CLIENTPIDMAP": {"sourceid", "uri"},
+}
+
+// Write cards as a <vcards> document
+func writeXCard(writer io.Writer, matches []Match, opts ExportOptions) error {
+	_, err := io.WriteString(writer, xml.Header)
+	if err != nil {
+		return err
+	}
+	x := &xcardWriter{encoder: xml.NewEncoder(writer)}
+	x.encoder.Indent("", "  ")
+
+	x.start("vcards", xml.Attr{Name: xml.Name{Local: "xmlns"}, Value: xcardNamespace})
+	for _, match := range matches {
+		// xCard is defined for vCard 4.0 only
+		props, err := exportProperties(match, "4.0", opts)
+		if err != nil {
+			return err
+		}
+		x.card(props)
+	}
+	x.end("vcards")
+	if x.err != nil {
+		return x.err
+	}
+	err = x.encoder.Flush()
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(writer, "\n")
+	return err
+}
+
+// keeps the first error, so that not every call has to be checked
+type xcardWriter struct {
+	encoder *xml.Encoder
+	err     error
+}
+
+func (x *xcardWriter) start(name string, attrs ...xml.Attr) {
+	if x.err == nil {
+		x.err = x.encoder.EncodeToken(xml.StartElement{Name: xml.Name{Local: name}, Attr: attrs})
+	}
+}
+
+func (x *xcardWriter) end(name string) {
+	if x.err == nil {
+		x.err = x.encoder.EncodeToken(xml.EndElement{Name: xml.Name{Local: name}})
+	}
+}
+
+// write <name>text</name>
+func (x *xcardWriter) element(name, text string) {
+	x.start(name)
+	if x.err == nil && text != "" {
+		x.err = x.encoder.EncodeToken(xml.CharData(text))
+	}
+	x.end(name)
+}
+
+func (x *xcardWriter) card(props []property) {
+	x.start("vcard")
+	done := map[string]bool{}
+	for _, prop := range props {
+		if prop.Group == "" {
+			x.property(prop)
+			continue
+		}
+		// all properties of a group go into one <group> element
+		if done[prop.Group] {
+			continue
+		}
+		done[prop.Group] = true
+		x.start("group", xml.Attr{Name: xml.Name{Local: "name"}, Value: prop.Group})
+		for _, member := range props {
+			if member.Group == prop.Group {
+				x.property(member)
+			}
+		}
+		x.end("group")
+	}
+	x.end("vcard")
+}
+
+func (x *xcardWriter) property(prop property) {
+	name := strings.ToLower(prop.Name)
+	x.start(name)
+	x.parameters(prop.Params)
+
+	vtype := valueType(prop)
+	if components, ok := xcardComponents[prop.Name]; ok {
+		parts := splitEscaped(prop.Value, ';')
+		for i, component := range components {
+			values := []string{""}
+			if i < len(parts) {
+				values = splitEscaped(parts[i], ',')
+			}
+			for _, v := range values {
+				x.element(component, unescapeText(v))
+			}
+		}
+	} else if prop.Name == "ORG" {
+		for _, part := range splitEscaped(prop.Value, ';') {
+			x.element("text", unescapeText(part))
+		}
+	} else {
+		values := []string{prop.Value}
+		if listProperties[prop.Name] {
+			values = splitEscaped(prop.Value, ',')
+		}
+		for _, v := range values {
+			if vtype == "text" {
+				v = unescapeText(v)
+			} else if isDateType(vtype) {
+				v = basicDateTime(v)
+			}
+			x.element(xcardValueElement(vtype, v), v)
+		}
+	}
+	x.end(name)
+}
+
+func (x *xcardWriter) parameters(params []param) {
+	started := false
+	for _, par := range params {
+		if par.Name == "VALUE" {
+			// expressed as the element name of the value
+			continue
+		}
+		if !started {
+			x.start("parameters")
+			started = true
+		}
+		name := strings.ToLower(par.Name)
+		vtype := "text"
+		if par.Name == "PREF" {
+			vtype = "integer"
+		}
+		x.start(name)
+		for _, v := range par.Values {
+			x.element(vtype, v)
+		}
+		x.end(name)
+	}
+	if started {
+		x.end("parameters")
+	}
+}
+
+// date-and-or-time values are written as date, time or date-time
+func xcardValueElement(vtype, value string) string {
+	if vtype != "date-and-or-time" {
+		return vtype
+	}
+	if strings.HasPrefix(value, "T") {
+		return "time"
+	} else if strings.Contains(value, "T") {
+		return "date-time"
+	}
+	return "date"
+}
+
+// a generic XML element
+type xmlNode struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr `xml:",any,attr"`
+	Nodes   []xmlNode  `xml:",any"`
+	Text    string     `xml:",chardata"`
+}
+
+func (n xmlNode) attr(name string) string {
+	for _, a := range n.Attrs {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// Read an xCard document with a <vcards> or a single <vcard> element.
+// Cards that cannot be converted are reported in the list of errors.
+func readXCard(reader io.Reader) ([]ImportedCard, []error, error) {
+	cards := []ImportedCard{}
+	failed := []error{}
+	var root xmlNode
+	err := xml.NewDecoder(reader).Decode(&root)
+	if err != nil {
+		return cards, failed, err
+	}
+	if root.XMLName.Space != xcardNamespace {
+		return cards, failed, fmt.Errorf("Expected namespace %s", xcardNamespace)
+	}
+
+	var items []xmlNode
+	switch root.XMLName.Local {
+	case "vcards":
+		items = root.Nodes
+	case "vcard":
+		items = []xmlNode{root}
+	default:
+		return cards, failed, errors.New("Expected <vcards> or <vcard>")
+	}
+
+	for i, item := range items {
+		if item.XMLName.Local != "vcard" {
+			failed = append(failed, fmt.Errorf("xCard %d: unexpected <%s>", i+1, item.XMLName.Local))
+			continue
+		}
+		props := []property{}
+		for _, node := range item.Nodes {
+			if node.XMLName.Local == "group" {
+				for _, member := range node.Nodes {
+					prop := xcardProperty(member)
+					prop.Group = node.attr("name")
+					props = append(props, prop)
+				}
+			} else {
+				props = append(props, xcardProperty(node))
+			}
+		}
+		card, err := propertiesCard(props)
+		if err != nil {
+			failed = append(failed, fmt.Errorf("xCard %d: %v", i+1, err))
+			continue
+		}
+		cards = append(cards, ImportedCard{card, props})
+	}
+	return cards, failed, nil
+}
+
+// convert a property element to a vCard property
+func xcardProperty(node xmlNode) property {
+	prop := property{Name: strings.ToUpper(node.XMLName.Local)}
+	values := []xmlNode{}
+	for _, child := range node.Nodes {
+		if child.XMLName.Local == "parameters" {
+			for _, par := range child.Nodes {
+				prop.Params = append(prop.Params, param{
+					strings.ToUpper(par.XMLName.Local), xmlTexts(par.Nodes)})
+			}
+		} else {
+			values = append(values, child)
+		}
+	}
+
+	if components, ok := xcardComponents[prop.Name]; ok {
+		parts := []string{}
+		for _, component := range components {
+			texts := []string{}
+			for _, v := range values {
+				if v.XMLName.Local == component {
+					texts = append(texts, escapeText(v.Text))
+				}
+			}
+			parts = append(parts, strings.Join(texts, ","))
+		}
+		prop.Value = strings.Join(parts, ";")
+		return prop
+	} else if prop.Name == "ORG" {
+		parts := []string{}
+		for _, v := range values {
+			parts = append(parts, escapeText(v.Text))
+		}
+		prop.Value = strings.Join(parts, ";")
+		return prop
+	}
+
+	vtype := defaultValueType(prop.Name)
+	texts := []string{}
+	for _, v := range values {
+		vtype = v.XMLName.Local
+		if vtype == "text" {
+			texts = append(texts, escapeText(v.Text))
+		} else {
+			texts = append(texts, v.Text)
+		}
+	}
+	prop.Value = strings.Join(texts, ",")
+
+	defaultType := defaultValueType(prop.Name)
+	dateValue := defaultType == "date-and-or-time" && isDateType(vtype)
+	if vtype != defaultType && vtype != "unknown" && !dateValue {
+		prop.Params = append(prop.Params, param{"VALUE", []string{vtype}})
+	}
+	return prop
+}
+
+func xmlTexts(nodes []xmlNode) []string {
+	texts := []string{}
+	for _, node := range nodes {
+		texts = append(texts, node.Text)
+	}
+	return texts
+}
@@ -0,0 +1,154 @@
+package contacts
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// the example from RFC 6351, section 5
+const rfc6351Example = `<?xml version="1.0" encoding="UTF-8"?>
+<vcards xmlns="urn:ietf:params:xml:ns:vcard-4.0">
+  <vcard>
+    <fn><text>Simon Perreault</text></fn>
+    <n>
+      <surname>Perreault</surname>
+      <given>Simon</given>
+      <additional/>
+      <prefix/>
+      <suffix>ing. jr</suffix>
+      <suffix>M.Sc.</suffix>
+    </n>
+    <bday><date>--0203</date></bday>
+    <anniversary>
+      <date-time>20090808T1430-0500</date-time>
+    </anniversary>
+    <gender><sex>M</sex></gender>
+    <lang>
+      <parameters><pref><integer>1</integer></pref></parameters>
+      <language-tag>fr</language-tag>
+    </lang>
+    <lang>
+      <parameters><pref><integer>2</integer></pref></parameters>
+      <language-tag>en</language-tag>
+    </lang>
+    <org>
+      <parameters><type><text>work</text></type></parameters>
+      <text>Viagenie</text>
+    </org>
+    <adr>
+      <parameters>
+        <type><text>work</text></type>
+        <label><text>Simon Perreault
+2875 boul. Laurier, suite D2-630
+Quebec, Canada
+G1V 2M2</text></label>
+      </parameters>
+      <pobox/>
+      <ext/>
+      <street>2875 boul. Laurier, suite D2-630</street>
+      <locality>Quebec</locality>
+      <region>QC</region>
+      <code>G1V 2M2</code>
+      <country>Canada</country>
+    </adr>
+    <tel>
+      <parameters>
+        <type>
+          <text>work</text>
+          <text>voice</text>
+        </type>
+      </parameters>
+      <uri>tel:+1-418-656-9254;ext=102</uri>
+    </tel>
+    <tel>
+      <parameters>
+        <type>
+          <text>work</text>
+          <text>text</text>
+          <text>voice</text>
+          <text>cell</text>
+          <text>video</text>
+        </type>
+      </parameters>
+      <uri>tel:+1-418-262-6501</uri>
+    </tel>
+    <email>
+      <parameters><type><text>work</text></type></parameters>
+      <text>simon.perreault@viagenie.ca</text>
+    </email>
+    <geo>
+      <parameters><type><text>work</text></type></parameters>
+      <uri>geo:46.766336,-71.28955</uri>
+    </geo>
+    <key>
+      <parameters><type><text>work</text></type></parameters>
+      <uri>http://www.viagenie.ca/simon.perreault/simon.asc</uri>
+    </key>
+    <tz><text>America/Montreal</text></tz>
+    <url>
+      <parameters><type><text>home</text></type></parameters>
+      <uri>http://nomis80.org</uri>
+    </url>
+  </vcard>
+</vcards>
+`
+
+// Import the example into an address book and export it again:
+// parameters and properties that vdir does not know are kept.
+func TestXCardImportExport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "contacts-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cards, failed, err := ReadCards(strings.NewReader(rfc6351Example), "xcard", ImportOptions{})
+	if err != nil || len(failed) > 0 {
+		t.Fatal(err, failed)
+	}
+	if len(cards) != 1 || cards[0].FormattedName != "Simon Perreault" {
+		t.Fatalf("Expected the card of Simon Perreault, got %v", cards)
+	}
+	book := NewAddressbook(dir)
+	err = book.Import(cards[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// from the file, as a new command would do
+	book = NewAddressbook(dir)
+	matches, err := book.Rank(Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	err = WriteCards(&buf, matches, "xcard", ExportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	xml := buf.String()
+	exported, failed, err := ReadCards(&buf, "xcard", ImportOptions{})
+	if err != nil || len(failed) > 0 || len(exported) != 1 {
+		t.Fatalf("Cannot read the export: %v %v\n%s", err, failed, xml)
+	}
+	// the UID is assigned on import
+	props := []property{}
+	for _, prop := range exported[0].props {
+		if prop.Name != "UID" {
+			props = append(props, prop)
+		}
+	}
+	if !reflect.DeepEqual(props, cards[0].props) {
+		t.Errorf("Export differs from the imported xCard:\n%v\n%v", props, cards[0].props)
+	}
+	for _, s := range []string{"<language-tag>fr</language-tag>", "<pref>", "<tz>", "<date>--0203</date>"} {
+		if !strings.Contains(xml, s) {
+			t.Errorf("Expected %s in the export:\n%s", s, xml)
+		}
+	}
+}